@@ -3,7 +3,6 @@ package concurrent
 import (
 	"fmt"
 	"sync"
-	"time"
 )
 
 /*
@@ -173,89 +172,11 @@ Channel通信:
 		}
 	}
 
-	4. 发布/订阅模型(Publisher, Topic, Subscriber)
+	4. 发布/订阅模型(Publisher, Topic, Subscriber), 见publisher.go
 	1) 发布者: 维持一个map, key是Subscriber, value是Topic
 	2) 订阅者:
 */
 
-type (
-	subscriber chan interface{}         // 订阅在为一个管道
-	topicFunc  func(v interface{}) bool //主题是一个过滤器
-)
-
-type Publisher struct {
-	m           sync.RWMutex             //读写锁
-	buffer      int                      // 订阅队列的缓存大小
-	timeout     time.Duration            // 发布超时时间
-	subscribers map[subscriber]topicFunc //订阅者信息
-}
-
-func NewPublisher(timeout time.Duration, buffer int) *Publisher {
-	return &Publisher{
-		buffer:      buffer,
-		timeout:     timeout,
-		subscribers: make(map[subscriber]topicFunc),
-	}
-}
-
-// 增加一个新的订阅者, 订阅全部主题
-func (p *Publisher) Subscribe() chan interface{} {
-	return p.SubscribeTopic(nil)
-}
-
-// 增加新的订阅者, 订阅过滤后的主题
-func (p *Publisher) SubscribeTopic(topic topicFunc) chan interface{} {
-	ch := make(chan interface{}, p.buffer)
-	p.m.Lock()
-	p.subscribers[ch] = topic
-	p.m.Unlock()
-
-	return ch
-}
-
-func (p *Publisher) Evict(sub chan interface{}) {
-	p.m.Lock()
-	defer p.m.Unlock()
-
-	delete(p.subscribers, sub)
-	close(sub)
-}
-
-// 发布
-func (p *Publisher) Publish(v interface{}) {
-	p.m.RLock()
-	defer p.m.RUnlock()
-
-	var wg sync.WaitGroup
-	for sub, topic := range p.subscribers {
-		wg.Add(1)
-		go p.sendTopic(sub, topic, v, &wg)
-	}
-	wg.Wait()
-}
-
-func (p *Publisher) Close() {
-	p.m.Lock()
-	defer p.m.Unlock()
-
-	for sub := range p.subscribers {
-		delete(p.subscribers, sub)
-		close(sub)
-	}
-}
-func (p *Publisher) sendTopic(sub subscriber, topic topicFunc, v interface{}, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	if topic != nil && !topic(v) {
-		return
-	}
-
-	select {
-	case sub <- v:
-	case <-time.After(p.timeout):
-	}
-}
-
 /*
 控制并发数:
 	go自带的godoc程序实现了一个vfs的包对应虚拟的的文件系统, 在vfs包下面有一个gatefs的子包, gatefs子包的
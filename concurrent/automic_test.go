@@ -2,45 +2,13 @@ package concurrent
 
 import (
 	"fmt"
-	"strings"
 	"testing"
-	"time"
 )
 
 func TestWorker(t *testing.T) {
 	Run()
 }
 
-func TestPublisher(t *testing.T) {
-	p := NewPublisher(100*time.Millisecond, 10)
-	defer p.Close()
-
-	all := p.Subscribe()
-	golang := p.SubscribeTopic(func(v interface{}) bool {
-		if s, ok := v.(string); ok {
-			return strings.Contains(s, "golang")
-		}
-		return false
-	})
-
-	p.Publish("Hello, world")
-	p.Publish("hello, golang")
-
-	go func() {
-		for msg := range all {
-			fmt.Println("all:", msg)
-		}
-	}()
-
-	go func() {
-		for msg := range golang {
-			fmt.Println("golang:", msg)
-		}
-	}()
-
-	time.Sleep(3 * time.Second)
-}
-
 // 素数筛, 每个并发体处理的任务粒度太细, 程序的整体性能并不理想
 func TestPrime(t *testing.T) {
 	var (
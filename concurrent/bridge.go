@@ -0,0 +1,601 @@
+package concurrent
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+网络桥接:
+	Server 把Publisher通过TCP暴露给其他进程, Client 连接Server订阅和发布消息.
+	协议是按行分隔的JSON, 每行一个frame:
+
+	客户端 -> 服务端
+		{"op":"sub","id":1,"pattern":"orders.*","from":10,"epoch":7}  订阅, pattern为空时订阅全部消息, from为重放的起始序列号,
+		                                                    epoch为from所属的Publisher
+		{"op":"unsub","id":1}                               取消订阅
+		{"op":"pub","topic":"orders.created","value":...}   发布, topic为空时调用Publish()
+	服务端 -> 客户端
+		{"op":"ok","id":1,"epoch":7,"seq":10}               订阅成功, epoch标识服务端的Publisher,
+		                                                    不重放时seq为订阅时最后发布的序列号
+		{"op":"msg","id":1,"seq":11,"topic":"...","reply":"...","value":...}  reply为请求的响应地址
+		{"op":"err","id":1,"error":"..."}                   订阅失败或者结束
+		{"op":"err","topic":"...","error":"..."}            发布失败
+
+	连接断开之后Client自动重连, 并从每个订阅最后收到的序列号(还没有收到消息时为订阅的起点)之后继续(FromSeq).
+	服务端的订阅都使用分发协程(WithOrdering), 序列号递增, 客户端丢弃不大于最后序列号的重复消息. 断开期间的消息只有在
+	服务端的Publisher开启了历史消息(SetHistory)并且仍然保留时才能找回.
+	序列号只在同一个Publisher内有意义: 服务端换成新的Publisher(例如进程重启)之后epoch改变, 服务端忽略旧的
+	序列号, 从新的Publisher保留的第一条消息开始重放, 客户端也重新开始去重.
+*/
+
+var (
+	ErrServerClosed = errors.New("server has been closed")
+	ErrDisconnected = errors.New("client is disconnected")
+	ErrClientClosed = errors.New("client has been closed")
+)
+
+const (
+	opSub   = "sub"
+	opUnsub = "unsub"
+	opPub   = "pub"
+	opOK    = "ok"
+	opMsg   = "msg"
+	opErr   = "err"
+
+	// 重连的间隔, 每次失败之后加倍
+	minReconnectDelay = 10 * time.Millisecond
+	maxReconnectDelay = time.Second
+
+	// 等待服务端确认订阅的时长
+	subscribeTimeout = 5 * time.Second
+)
+
+// frame 协议当中的一行
+type frame struct {
+	Op      string          `json:"op"`
+	ID      uint64          `json:"id,omitempty"`
+	Pattern string          `json:"pattern,omitempty"`
+	From    uint64          `json:"from,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Topic   string          `json:"topic,omitempty"`
+	Reply   string          `json:"reply,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Epoch   uint64          `json:"epoch,omitempty"`
+}
+
+//-------------------------------------------------------------------------
+
+// Server 通过TCP暴露Publisher. 消息类型T需要可以使用JSON编码
+type Server[T any] struct {
+	publisher *Publisher[T]
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewServer[T any](p *Publisher[T]) *Server[T] {
+	return &Server[T]{
+		publisher: p,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// 接收连接, 直到Close()之后返回ErrServerClosed
+func (s *Server[T]) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// 关闭所有的监听和连接, 连接上的订阅被取消. 不关闭Publisher
+func (s *Server[T]) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// serverConn 服务端的一个连接
+type serverConn[T any] struct {
+	server *Server[T]
+	conn   net.Conn
+
+	wlock   sync.Mutex // 保护encoder
+	encoder *json.Encoder
+
+	subs map[uint64]*Subscription[T] // 只在读协程当中访问
+}
+
+func (s *Server[T]) serveConn(conn net.Conn) {
+	c := &serverConn[T]{
+		server:  s,
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		subs:    make(map[uint64]*Subscription[T]),
+	}
+	defer func() {
+		for _, sub := range c.subs {
+			sub.Unsubscribe()
+		}
+		conn.Close()
+
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var f frame
+		if err := decoder.Decode(&f); err != nil {
+			return
+		}
+		switch f.Op {
+		case opSub:
+			c.subscribe(f)
+		case opUnsub:
+			if sub, ok := c.subs[f.ID]; ok {
+				delete(c.subs, f.ID)
+				sub.Unsubscribe()
+			}
+		case opPub:
+			c.publish(f)
+		}
+	}
+}
+
+func (c *serverConn[T]) subscribe(f frame) {
+	if _, ok := c.subs[f.ID]; ok {
+		c.write(frame{Op: opErr, ID: f.ID, Error: "duplicate subscription id"})
+		return
+	}
+
+	publisher := c.server.publisher
+	from := f.From
+	if from > 0 && f.Epoch != 0 && f.Epoch != publisher.epoch {
+		from = 1 // 客户端的序列号属于其他Publisher, 重放全部保留的消息
+	}
+	// 分发协程按照序列号递增的顺序投递, 客户端可以用序列号去重
+	options := []SubscribeOption{WithEnvelopes(), WithOrdering()}
+	if from > 0 {
+		options = append(options, FromSeq(from))
+	}
+	var (
+		sub *Subscription[T]
+		err error
+	)
+	if f.Pattern == "" {
+		sub, err = publisher.Subscribe(options...)
+	} else {
+		sub, err = publisher.SubscribePattern(f.Pattern, options...)
+	}
+	if err != nil {
+		c.write(frame{Op: opErr, ID: f.ID, Error: err.Error()})
+		return
+	}
+
+	c.subs[f.ID] = sub
+	// 在转发之前确认, 客户端先处理epoch. 不重放时告诉客户端订阅的起点, 断开之后从这里继续
+	ok := frame{Op: opOK, ID: f.ID, Epoch: publisher.epoch}
+	if from == 0 {
+		ok.Seq = sub.since
+	}
+	c.write(ok)
+	go c.forward(f.ID, sub)
+}
+
+// 把订阅收到的消息转发给客户端. 订阅不是被客户端取消时通知客户端
+func (c *serverConn[T]) forward(id uint64, sub *Subscription[T]) {
+	for e := range sub.Envelopes() {
+		value, err := json.Marshal(e.Value)
+		if err != nil {
+			continue
+		}
+		if err = c.write(frame{Op: opMsg, ID: id, Seq: e.Seq, Topic: e.Topic, Reply: e.Reply, Value: value}); err != nil {
+			c.conn.Close() // 读协程退出, 取消所有订阅
+			return
+		}
+	}
+	if err := sub.Err(); err != ErrUnsubscribed {
+		c.write(frame{Op: opErr, ID: id, Error: err.Error()})
+	}
+}
+
+// 发布客户端的消息, 失败时回复err frame
+func (c *serverConn[T]) publish(f frame) {
+	var v T
+	err := json.Unmarshal(f.Value, &v)
+	if err == nil {
+		if f.Topic == "" {
+			err = c.server.publisher.Publish(v)
+		} else {
+			err = c.server.publisher.PublishTopic(f.Topic, v)
+		}
+	}
+	if err != nil {
+		c.write(frame{Op: opErr, Topic: f.Topic, Error: err.Error()})
+	}
+}
+
+func (c *serverConn[T]) write(f frame) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	return c.encoder.Encode(f)
+}
+
+//-------------------------------------------------------------------------
+
+// Client 连接Server的客户端, 连接断开之后自动重连并恢复订阅
+type Client[T any] struct {
+	addr string
+
+	lock    sync.Mutex
+	conn    net.Conn // 当前的连接, 断开时为nil
+	encoder *json.Encoder
+	subs    map[uint64]*RemoteSubscription[T]
+	nextID  uint64
+	closed  bool
+
+	onPublishError func(topic string, err error) // 服务端发布失败时的回调
+
+	done chan struct{} // 读协程退出之后关闭
+}
+
+// 连接服务端
+func Dial[T any](addr string) (*Client[T], error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client[T]{
+		addr:    addr,
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		subs:    make(map[uint64]*RemoteSubscription[T]),
+		done:    make(chan struct{}),
+	}
+	go c.run(conn)
+	return c, nil
+}
+
+// 订阅与pattern匹配的主题, pattern为空时订阅全部消息. from大于0时从该序列号开始重放.
+// 等待服务端确认之后返回
+func (c *Client[T]) Subscribe(pattern string, from uint64) (*RemoteSubscription[T], error) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, ErrClientClosed
+	}
+	if c.conn == nil {
+		c.lock.Unlock()
+		return nil, ErrDisconnected
+	}
+
+	c.nextID++
+	sub := &RemoteSubscription[T]{
+		client:  c,
+		id:      c.nextID,
+		pattern: pattern,
+		from:    from,
+		ch:      make(chan Envelope[T], 64),
+		done:    make(chan struct{}),
+		ready:   make(chan error, 1),
+	}
+	c.subs[sub.id] = sub
+	err := c.encoder.Encode(frame{Op: opSub, ID: sub.id, Pattern: pattern, From: from})
+	c.lock.Unlock()
+
+	if err == nil {
+		select {
+		case err = <-sub.ready:
+		case <-time.After(subscribeTimeout):
+			err = ErrDisconnected
+		}
+	}
+	if err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// 发布消息, topic为空时服务端调用Publish(). 连接断开时返回ErrDisconnected
+func (c *Client[T]) Publish(topic string, v T) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+	if c.conn == nil {
+		return ErrDisconnected
+	}
+	return c.encoder.Encode(frame{Op: opPub, Topic: topic, Value: value})
+}
+
+// 设置服务端发布失败时的回调, 在读协程当中调用. Publish()只等待消息写入连接, 发布的结果通过回调异步通知
+func (c *Client[T]) OnPublishError(fn func(topic string, err error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onPublishError = fn
+}
+
+// 关闭连接, 结束所有订阅
+func (c *Client[T]) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	subs := c.subs
+	c.subs = make(map[uint64]*RemoteSubscription[T])
+	c.lock.Unlock()
+
+	// 先唤醒阻塞在投递当中的读协程, 再等待它退出
+	for _, sub := range subs {
+		sub.doneOnce.Do(func() { close(sub.done) })
+	}
+	<-c.done
+	for _, sub := range subs {
+		sub.close()
+	}
+	return nil
+}
+
+// 读协程: 读取连接上的frame, 连接断开之后重连
+func (c *Client[T]) run(conn net.Conn) {
+	defer close(c.done)
+
+	for conn != nil {
+		c.read(conn)
+		conn = c.reconnect()
+	}
+}
+
+func (c *Client[T]) read(conn net.Conn) {
+	decoder := json.NewDecoder(conn)
+	for {
+		var f frame
+		if err := decoder.Decode(&f); err != nil {
+			conn.Close()
+			return
+		}
+
+		c.lock.Lock()
+		sub, ok := c.subs[f.ID]
+		onPublishError := c.onPublishError
+		c.lock.Unlock()
+		if f.Op == opErr && f.ID == 0 {
+			if onPublishError != nil {
+				onPublishError(f.Topic, errors.New(f.Error))
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		switch f.Op {
+		case opOK:
+			sub.switchEpoch(f.Epoch)
+			if f.Seq > sub.last {
+				sub.last = f.Seq // 订阅之前的消息不需要在重连之后重放
+			}
+			sub.notify(nil)
+		case opErr:
+			sub.notify(errors.New(f.Error))
+		case opMsg:
+			sub.deliver(f)
+		}
+	}
+}
+
+// 重连并恢复订阅, 客户端关闭时返回nil
+func (c *Client[T]) reconnect() net.Conn {
+	c.lock.Lock()
+	c.conn = nil
+	c.lock.Unlock()
+
+	delay := minReconnectDelay
+	for {
+		c.lock.Lock()
+		closed := c.closed
+		c.lock.Unlock()
+		if closed {
+			return nil
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+
+		conn, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			continue
+		}
+
+		c.lock.Lock()
+		if c.closed {
+			c.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		encoder := json.NewEncoder(conn)
+		for _, sub := range c.subs {
+			if err = encoder.Encode(frame{Op: opSub, ID: sub.id, Pattern: sub.pattern, From: sub.resumeFrom(), Epoch: sub.epoch}); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			c.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		c.conn, c.encoder = conn, encoder
+		c.lock.Unlock()
+		return conn
+	}
+}
+
+// RemoteSubscription 客户端的订阅
+type RemoteSubscription[T any] struct {
+	client  *Client[T]
+	id      uint64
+	pattern string
+	from    uint64 // 订阅时指定的起始序列号
+
+	lock     sync.Mutex // 保护ch的关闭
+	ch       chan Envelope[T]
+	closed   bool
+	done     chan struct{} // 取消订阅时关闭, 唤醒阻塞在投递当中的读协程
+	doneOnce sync.Once
+
+	// 以下字段只在读协程当中访问
+	last      uint64     // 最后收到的序列号
+	epoch     uint64     // last所属的Publisher, 0表示还没有收到确认
+	confirmed bool       // 服务端是否已经确认过订阅
+	ready     chan error // 通知Subscribe()服务端第一次确认的结果
+}
+
+// 接收消息的管道, 取消订阅或者客户端关闭之后被关闭
+func (sub *RemoteSubscription[T]) C() <-chan Envelope[T] {
+	return sub.ch
+}
+
+// 取消订阅, 可以重复调用
+func (sub *RemoteSubscription[T]) Unsubscribe() {
+	c := sub.client
+	c.lock.Lock()
+	if _, ok := c.subs[sub.id]; ok {
+		delete(c.subs, sub.id)
+		if c.conn != nil {
+			c.encoder.Encode(frame{Op: opUnsub, ID: sub.id})
+		}
+	}
+	c.lock.Unlock()
+
+	sub.close()
+}
+
+// 先关闭done唤醒阻塞的投递, 再关闭ch
+func (sub *RemoteSubscription[T]) close() {
+	sub.doneOnce.Do(func() { close(sub.done) })
+
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+// 重连之后的起始序列号
+func (sub *RemoteSubscription[T]) resumeFrom() uint64 {
+	if sub.last > 0 {
+		return sub.last + 1
+	}
+	return sub.from
+}
+
+// 服务端的Publisher改变之后, 旧的序列号不再有意义, 重新开始去重
+func (sub *RemoteSubscription[T]) switchEpoch(epoch uint64) {
+	if epoch != sub.epoch {
+		sub.epoch = epoch
+		sub.last = 0
+	}
+}
+
+// 处理服务端的确认. 第一次确认通知Subscribe(), 之后的错误表示服务端结束了订阅
+func (sub *RemoteSubscription[T]) notify(err error) {
+	if !sub.confirmed {
+		sub.confirmed = true
+		sub.ready <- err
+		return
+	}
+	if err != nil {
+		sub.Unsubscribe()
+	}
+}
+
+// 投递消息, 忽略重连之后重复的消息. 消费者处理不及时会阻塞读协程
+func (sub *RemoteSubscription[T]) deliver(f frame) {
+	if f.Seq <= sub.last {
+		return
+	}
+	e := Envelope[T]{Seq: f.Seq, Topic: f.Topic, Reply: f.Reply}
+	if err := json.Unmarshal(f.Value, &e.Value); err != nil {
+		return
+	}
+
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if sub.closed {
+		return
+	}
+	select {
+	case sub.ch <- e:
+		sub.last = f.Seq
+	case <-sub.done:
+	}
+}
@@ -0,0 +1,341 @@
+package concurrent
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, p *Publisher[string], addr string) (*Server[string], string) {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(p)
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func receiveRemote(t *testing.T, sub *RemoteSubscription[string], want ...Envelope[string]) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case e := <-sub.C():
+			if e != w {
+				t.Fatalf("unexpected envelope: %+v, want: %+v", e, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("envelope %+v is not received", w)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	s, addr := serve(t, p, "127.0.0.1:0")
+	defer s.Close()
+
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub, err := c.Subscribe("orders.*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Subscribe("orders.>.x", 0); err == nil {
+		t.Fatal("invalid pattern should be rejected")
+	}
+
+	p.PublishTopic("orders.created", "a")
+	p.PublishTopic("users.created", "b")
+	p.PublishTopic("orders.paid", "c")
+	receiveRemote(t, sub,
+		Envelope[string]{Seq: 1, Topic: "orders.created", Value: "a"},
+		Envelope[string]{Seq: 3, Topic: "orders.paid", Value: "c"},
+	)
+
+	// 客户端发布的消息被服务端的订阅者收到
+	local, _ := p.SubscribePattern("remote.*")
+	if err = c.Publish("remote.hello", "d"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-local.C():
+		if v != "d" {
+			t.Fatalf("unexpected message: %v", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message is not published")
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("unsubscribed subscription should be closed")
+	}
+}
+
+func TestBridgeResume(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	p.SetHistory(16)
+
+	s, addr := serve(t, p, "127.0.0.1:0")
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub, err := c.Subscribe("orders.*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.PublishTopic("orders.created", "a")
+	receiveRemote(t, sub, Envelope[string]{Seq: 1, Topic: "orders.created", Value: "a"})
+
+	// 服务端重启, 断开期间发布的消息在重连之后重放
+	s.Close()
+	p.PublishTopic("orders.paid", "b")
+	p.PublishTopic("orders.shipped", "c")
+	if err = c.Publish("orders.x", "lost"); err == nil {
+		// 连接断开可能还没有被客户端发现, 发布成功也可以
+		t.Log("publish before disconnect is detected")
+	}
+
+	s, _ = serve(t, p, addr)
+	defer s.Close()
+	receiveRemote(t, sub,
+		Envelope[string]{Seq: 2, Topic: "orders.paid", Value: "b"},
+		Envelope[string]{Seq: 3, Topic: "orders.shipped", Value: "c"},
+	)
+
+	p.PublishTopic("orders.done", "d")
+	receiveRemote(t, sub, Envelope[string]{Seq: 4, Topic: "orders.done", Value: "d"})
+}
+
+func TestBridgeRestart(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	p.SetHistory(16)
+	s, addr := serve(t, p, "127.0.0.1:0")
+
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub, err := c.Subscribe("orders.*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.PublishTopic("orders.created", "a")
+	p.PublishTopic("orders.paid", "b")
+	receiveRemote(t, sub,
+		Envelope[string]{Seq: 1, Topic: "orders.created", Value: "a"},
+		Envelope[string]{Seq: 2, Topic: "orders.paid", Value: "b"},
+	)
+
+	// 服务端进程重启, 新的Publisher的序列号从1开始, 客户端不能把新消息当作重复的消息丢弃
+	s.Close()
+	p = NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	p.SetHistory(16)
+	p.PublishTopic("orders.shipped", "c")
+
+	s, _ = serve(t, p, addr)
+	defer s.Close()
+	receiveRemote(t, sub, Envelope[string]{Seq: 1, Topic: "orders.shipped", Value: "c"})
+
+	p.PublishTopic("orders.done", "d")
+	receiveRemote(t, sub, Envelope[string]{Seq: 2, Topic: "orders.done", Value: "d"})
+}
+
+func TestBridgePublishError(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	s, addr := serve(t, p, "127.0.0.1:0")
+	defer s.Close()
+
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	errs := make(chan string, 1)
+	c.OnPublishError(func(topic string, err error) {
+		errs <- topic + ": " + err.Error()
+	})
+	if err = c.Publish("orders.*", "a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-errs:
+		if e != "orders.*: "+ErrInvalidTopic.Error() {
+			t.Fatalf("unexpected error: %v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish error is not reported")
+	}
+
+	// 消息无法解码为T
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"op":"pub","topic":"orders.created","value":1}` + "\n"))
+	var f frame
+	if err = json.NewDecoder(conn).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Op != opErr || f.Topic != "orders.created" || f.Error == "" {
+		t.Fatalf("unexpected frame: %+v", f)
+	}
+}
+
+// 多个连接并发发布, 远程订阅者按照序列号递增的顺序收到全部消息
+func TestBridgeConcurrentPublish(t *testing.T) {
+	const (
+		publishers = 8
+		messages   = 500
+	)
+	p := NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	s, addr := serve(t, p, "127.0.0.1:0")
+	defer s.Close()
+
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sub, err := c.Subscribe("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < publishers; i++ {
+		pc, err := Dial[string](addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+		go func() {
+			for j := 0; j < messages; j++ {
+				pc.Publish("", "x")
+			}
+		}()
+	}
+
+	var last uint64
+	for i := 0; i < publishers*messages; i++ {
+		select {
+		case e := <-sub.C():
+			if e.Seq <= last {
+				t.Fatalf("sequence goes backwards: %d after %d", e.Seq, last)
+			}
+			last = e.Seq
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d messages are received", i, publishers*messages)
+		}
+	}
+}
+
+// 订阅之后还没有收到消息就断开, 断开期间的消息在重连之后重放
+func TestBridgeResumeBeforeFirstMessage(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	p.SetHistory(16)
+	p.PublishTopic("orders.created", "a") // 订阅之前的消息不重放
+
+	s, addr := serve(t, p, "127.0.0.1:0")
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sub, err := c.Subscribe("orders.*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	p.PublishTopic("orders.paid", "b")
+	s, _ = serve(t, p, addr)
+	defer s.Close()
+	receiveRemote(t, sub, Envelope[string]{Seq: 2, Topic: "orders.paid", Value: "b"})
+}
+
+// 订阅者不接收消息, 读协程阻塞在投递当中时Close()也能返回
+func TestBridgeCloseBlocked(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	defer p.Close()
+	s, addr := serve(t, p, "127.0.0.1:0")
+	defer s.Close()
+
+	c, err := Dial[string](addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		p.Publish("x")
+	}
+	time.Sleep(100 * time.Millisecond) // 等待读协程填满订阅的缓存
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() is blocked by a full subscription")
+	}
+	for range sub.C() {
+	}
+}
+
+func TestBridgeClose(t *testing.T) {
+	p := NewPublisher[string](time.Second, 16)
+	s, addr := serve(t, p, "127.0.0.1:0")
+	defer s.Close()
+
+	c, _ := Dial[string](addr)
+	sub, err := c.Subscribe("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端的Publisher关闭, 远程订阅随之结束
+	p.Close()
+	select {
+	case _, ok := <-sub.C():
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("remote subscription should be closed")
+	}
+
+	c.Close()
+	c.Close()
+	if err = c.Publish("a", "b"); err != ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = c.Subscribe("a", 0); err != ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
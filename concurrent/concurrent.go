@@ -1,7 +1,10 @@
 package concurrent
 
 import (
-	"sync/atomic"
+	"context"
+	"errors"
+	"sync"
+	"time"
 )
 
 /*
@@ -40,29 +43,117 @@ WaitGroup的特点是Wait()可以用来阻塞直到队列中的所有任务都
 	wg.Wait() // 阻塞
 */
 
+var ErrWaitTimeout = errors.New("wait timeout")
+
+// ChannelGroup 使用channel实现的WaitGroup, 可以设置等待的超时时间, 并收集Go()执行的函数返回的错误.
+// 计数器归零之后可以重新使用, 每一轮(计数器从0变为正数到再次归零)有独立的错误. 零值可以直接使用
 type ChannelGroup struct {
-	channel chan bool
+	lock    sync.Mutex
 	counter int32
+	round   *groupRound // 当前一轮, nil表示计数器为0并且没有开始过
+}
+
+// groupRound 一轮等待, 计数器归零时关闭done
+type groupRound struct {
+	done chan struct{}
+	err  error // 第一个错误
 }
 
+// 已经结束的一轮, 计数器为0时Wait()立即返回
+var finishedRound = func() *groupRound {
+	r := &groupRound{done: make(chan struct{})}
+	close(r.done)
+	return r
+}()
+
 func NewChannelGroup() *ChannelGroup {
-	return &ChannelGroup{
-		channel: make(chan bool),
-		counter: 0,
-	}
+	return &ChannelGroup{}
 }
 
+// 增加计数器, delta可以为负数. 计数器小于0时panic
 func (c *ChannelGroup) Add(delta int32) {
-	atomic.AddInt32(&c.counter, delta)
-	if c.counter <= 0 {
-		close(c.channel)
+	c.add(delta)
+}
+
+func (c *ChannelGroup) add(delta int32) *groupRound {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.counter == 0 && delta > 0 {
+		c.round = &groupRound{done: make(chan struct{})} // 开始新的一轮
+	}
+	c.counter += delta
+	if c.counter < 0 {
+		panic("concurrent: negative ChannelGroup counter")
 	}
+	if c.counter == 0 && delta < 0 {
+		close(c.round.done)
+	}
+	return c.round
 }
 
 func (c *ChannelGroup) Done() {
 	c.Add(-1)
 }
 
-func (c *ChannelGroup) Wait() {
-	<-c.channel
+// 在新的协程当中执行fn, 记录第一个返回的错误, 由这一轮的Wait()返回
+func (c *ChannelGroup) Go(fn func() error) {
+	round := c.add(1)
+	go func() {
+		defer c.Done()
+		if err := fn(); err != nil {
+			c.lock.Lock()
+			if round.err == nil {
+				round.err = err
+			}
+			c.lock.Unlock()
+		}
+	}()
+}
+
+// 等待计数器归零, 返回这一轮Go()执行的函数的第一个错误
+func (c *ChannelGroup) Wait() error {
+	round := c.current()
+	<-round.done
+	return c.result(round)
+}
+
+// 最多等待d, 超时返回ErrWaitTimeout
+func (c *ChannelGroup) WaitTimeout(d time.Duration) error {
+	round := c.current()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-round.done:
+		return c.result(round)
+	case <-timer.C:
+		return ErrWaitTimeout
+	}
+}
+
+// 等待计数器归零或者ctx结束, ctx结束时返回ctx.Err()
+func (c *ChannelGroup) WaitContext(ctx context.Context) error {
+	round := c.current()
+	select {
+	case <-round.done:
+		return c.result(round)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *ChannelGroup) current() *groupRound {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.counter == 0 && c.round == nil {
+		return finishedRound
+	}
+	return c.round
+}
+
+func (c *ChannelGroup) result(round *groupRound) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return round.err
 }
@@ -1,9 +1,13 @@
 package concurrent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 /*
@@ -35,3 +39,79 @@ func TestWaitGroup(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestChannelGroupReuse(t *testing.T) {
+	var cg ChannelGroup
+	if err := cg.WaitTimeout(time.Second); err != nil {
+		t.Fatalf("wait without Add should return immediately: %v", err)
+	}
+
+	for round := 0; round < 3; round++ {
+		var count int32
+		for i := 0; i < 100; i++ {
+			cg.Add(1)
+			go func() {
+				atomic.AddInt32(&count, 1)
+				cg.Done()
+			}()
+		}
+		if err := cg.Wait(); err != nil || atomic.LoadInt32(&count) != 100 {
+			t.Fatalf("round %d, unexpected count: %d, error: %v", round, count, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("negative counter should panic")
+		}
+	}()
+	cg.Done()
+}
+
+func TestChannelGroupWaitTimeout(t *testing.T) {
+	cg := NewChannelGroup()
+	release := make(chan struct{})
+	cg.Add(1)
+	go func() {
+		<-release
+		cg.Done()
+	}()
+
+	if err := cg.WaitTimeout(20 * time.Millisecond); err != ErrWaitTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cg.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	close(release)
+	if err := cg.WaitContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestChannelGroupGo(t *testing.T) {
+	cg := NewChannelGroup()
+	first := errors.New("first")
+	block := make(chan struct{})
+	cg.Go(func() error { return first })
+	cg.Go(func() error {
+		<-block
+		return errors.New("second")
+	})
+	cg.Go(func() error { return nil })
+
+	time.Sleep(20 * time.Millisecond) // 第一个错误已经记录之后再返回第二个错误
+	close(block)
+	if err := cg.Wait(); err != first {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 新的一轮不保留上一轮的错误
+	cg.Go(func() error { return nil })
+	if err := cg.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
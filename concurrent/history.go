@@ -0,0 +1,151 @@
+package concurrent
+
+import (
+	"sort"
+)
+
+/*
+历史消息:
+	SetHistory(n) 开启之后, 发布者为每个主题保留最近的n条消息(环形缓冲区), 没有主题的消息保存在空主题下.
+	请求的响应(inboxPrefix开头的主题)只属于一个请求, 不保留.
+	订阅时可以指定从哪里开始重放:
+	1) FromLatest()   每个匹配的主题最近的一条消息, 类似MQTT的retained消息
+	2) FromLast(n)    每个匹配的主题最近的n条消息
+	3) FromSeq(seq)   序列号大于等于seq的所有保留的消息
+
+	重放的消息按照序列号排序, 在订阅之后发布的消息之前投递, 不重复也不遗漏.
+	需要重放的订阅使用分发协程投递(与WithOrdering()相同), 避免重放的消息超过订阅者的缓存.
+*/
+
+type replayMode int
+
+const (
+	replayNone replayMode = iota
+	replayLatest
+	replayLast
+	replaySeq
+)
+
+// 重放每个匹配的主题最近的一条消息
+func FromLatest() SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.replay = replayLatest
+	}
+}
+
+// 重放每个匹配的主题最近的n条消息, n小于0时与0相同
+func FromLast(n int) SubscribeOption {
+	if n < 0 {
+		n = 0
+	}
+	return func(opts *subscribeOptions) {
+		opts.replay = replayLast
+		opts.replayN = n
+	}
+}
+
+// 重放序列号大于等于seq的所有保留的消息, 用于断开之后从上一次收到的位置继续
+func FromSeq(seq uint64) SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.replay = replaySeq
+		opts.replaySeq = seq
+	}
+}
+
+// ring 保存一个主题最近的消息的环形缓冲区
+type ring[T any] struct {
+	items []Envelope[T]
+	start int // 最旧的消息的位置
+	size  int
+}
+
+func newRing[T any](capacity int) *ring[T] {
+	return &ring[T]{items: make([]Envelope[T], capacity)}
+}
+
+func (r *ring[T]) push(e Envelope[T]) {
+	if r.size < len(r.items) {
+		r.items[(r.start+r.size)%len(r.items)] = e
+		r.size++
+		return
+	}
+	r.items[r.start] = e
+	r.start = (r.start + 1) % len(r.items)
+}
+
+// 按照从旧到新的顺序返回最近的n条消息
+func (r *ring[T]) last(n int) []Envelope[T] {
+	if n > r.size {
+		n = r.size
+	}
+	if n < 0 {
+		n = 0
+	}
+	out := make([]Envelope[T], 0, n)
+	for i := r.size - n; i < r.size; i++ {
+		out = append(out, r.items[(r.start+i)%len(r.items)])
+	}
+	return out
+}
+
+// 设置每个主题保留的历史消息数, 0表示不保留. 修改之后已经保留的消息被清空
+func (p *Publisher[T]) SetHistory(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.historySize = n
+	p.history = nil
+	if n > 0 {
+		p.history = make(map[string]*ring[T])
+	}
+}
+
+// 保存历史消息. 调用方需要持有order锁
+func (p *Publisher[T]) retain(e Envelope[T]) {
+	if p.history == nil || isInbox(e.Topic) {
+		return
+	}
+	r, ok := p.history[e.Topic]
+	if !ok {
+		r = newRing[T](p.historySize)
+		p.history[e.Topic] = r
+	}
+	r.push(e)
+}
+
+// 选择需要向订阅者重放的历史消息, 按照序列号排序. 调用方需要持有写锁
+func (p *Publisher[T]) replay(sub *Subscription[T], opts *subscribeOptions) []Envelope[T] {
+	var out []Envelope[T]
+	for topic, r := range p.history {
+		if !sub.matchTopic(topic) {
+			continue
+		}
+		switch opts.replay {
+		case replayLatest:
+			out = append(out, r.last(1)...)
+		case replayLast:
+			out = append(out, r.last(opts.replayN)...)
+		case replaySeq:
+			for _, e := range r.last(r.size) {
+				if e.Seq >= opts.replaySeq {
+					out = append(out, e)
+				}
+			}
+		}
+	}
+
+	filtered := out[:0]
+	for _, e := range out {
+		if sub.filter == nil || sub.filter(e.Value) {
+			filtered = append(filtered, e)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].Seq < filtered[j].Seq
+	})
+	return filtered
+}
@@ -0,0 +1,103 @@
+package concurrent
+
+import (
+	"testing"
+	"time"
+)
+
+func receiveSeqs(t *testing.T, sub *Subscription[int], want ...uint64) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case e := <-sub.Envelopes():
+			if e.Seq != w {
+				t.Fatalf("unexpected seq: %d, want: %d", e.Seq, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("seq %d is not received", w)
+		}
+	}
+	select {
+	case e := <-sub.Envelopes():
+		t.Fatalf("unexpected envelope: %+v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	p := NewPublisher[int](time.Second, 1)
+	defer p.Close()
+	p.SetHistory(3)
+
+	// 序列号: orders.a 1,3,5,7; orders.b 2,4,6; users.a 8
+	for i := 1; i <= 7; i++ {
+		if i%2 == 1 {
+			p.PublishTopic("orders.a", i)
+		} else {
+			p.PublishTopic("orders.b", i)
+		}
+	}
+	p.PublishTopic("users.a", 8)
+
+	latest, _ := p.SubscribePattern("orders.*", FromLatest(), WithEnvelopes())
+	receiveSeqs(t, latest, 6, 7)
+
+	last, _ := p.SubscribePattern("orders.a", FromLast(2), WithEnvelopes())
+	receiveSeqs(t, last, 5, 7)
+
+	none, _ := p.SubscribePattern("orders.a", FromLast(-1), WithEnvelopes())
+	receiveSeqs(t, none)
+
+	// 每个主题只保留3条, orders.a的1已经被覆盖
+	fromSeq, _ := p.Subscribe(FromSeq(1), WithEnvelopes())
+	receiveSeqs(t, fromSeq, 2, 3, 4, 5, 6, 7, 8)
+
+	// 重放之后继续接收新的消息
+	p.PublishTopic("orders.b", 9)
+	receiveSeqs(t, latest, 9)
+	receiveSeqs(t, fromSeq, 9)
+	receiveSeqs(t, last)
+}
+
+func TestHistoryFilter(t *testing.T) {
+	p := NewPublisher[int](time.Second, 10)
+	defer p.Close()
+	p.SetHistory(10)
+
+	for i := 1; i <= 6; i++ {
+		p.Publish(i)
+	}
+	even, _ := p.SubscribeTopic(func(v int) bool { return v%2 == 0 }, FromLast(10))
+	for _, want := range []int{2, 4, 6} {
+		if v := <-even.C(); v != want {
+			t.Fatalf("unexpected message: %d, want: %d", v, want)
+		}
+	}
+
+	// 没有开启历史消息时不重放
+	p.SetHistory(0)
+	p.Publish(7)
+	none, _ := p.Subscribe(FromLatest())
+	select {
+	case v := <-none.C():
+		t.Fatalf("unexpected message: %d", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := newRing[int](3)
+	for i := 1; i <= 5; i++ {
+		r.push(Envelope[int]{Seq: uint64(i)})
+	}
+	got := r.last(10)
+	if len(got) != 3 || got[0].Seq != 3 || got[2].Seq != 5 {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+	if got = r.last(1); len(got) != 1 || got[0].Seq != 5 {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+	if got = r.last(-1); len(got) != 0 {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+}
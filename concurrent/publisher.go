@@ -0,0 +1,256 @@
+package concurrent
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+发布/订阅模型:
+	订阅者有三种方式订阅消息:
+	1) Subscribe()          订阅全部消息
+	2) SubscribeTopic(f)    使用过滤函数订阅, 每条消息都需要调用过滤函数
+	3) SubscribePattern(s)  按照主题订阅, 支持通配符, 发布时通过前缀树查找, 与订阅者的数量无关
+
+	Publish(v) 发布没有主题的消息, 只有前两种订阅者可以收到; PublishTopic(topic, v) 发布带有主题的消息.
+
+	订阅者的缓存已满时, 按照订阅时指定的投递策略(DeliveryPolicy)处理, 丢弃的消息被计数并回调OnDrop.
+
+	订阅返回Subscription句柄, 通过C()接收消息. 订阅结束(取消订阅, 发布者关闭或者因为消费过慢被断开)之后
+	管道被关闭, Err()返回结束的原因.
+
+	顺序: 每条消息分配递增的序列号. 并发调用Publish()时, 直接投递的订阅者之间看到的顺序可能不同;
+	使用WithOrdering()订阅的订阅者通过分发协程投递, 所有这样的订阅者看到相同的顺序.
+
+	请求/响应和队列组见 request.go.
+*/
+
+var (
+	ErrInvalidTopic    = errors.New("invalid topic")
+	ErrPublisherClosed = errors.New("publisher has been closed")
+	ErrUnsubscribed    = errors.New("subscription has been unsubscribed")
+	ErrSlowConsumer    = errors.New("subscription is disconnected for slow consumer")
+)
+
+type Publisher[T any] struct {
+	m           sync.RWMutex                  //读写锁
+	buffer      int                           // 订阅队列的缓存大小
+	timeout     time.Duration                 // 发布超时时间
+	closed      bool                          // 是否已经关闭
+	subscribers map[*Subscription[T]]struct{} //订阅者信息
+	broadcast   map[*Subscription[T]]struct{} // 订阅全部消息或者使用过滤函数的订阅者
+	topics      *topicNode[T]                 // 按照主题订阅的索引
+
+	order sync.Mutex // 分配序列号并放入分发队列, 保证分发协程之间的顺序一致
+	seq   uint64     // 最后分配的序列号
+	epoch uint64     // 创建时随机生成, 序列号只在同一个epoch内可以比较
+
+	historySize int                 // 每个主题保留的历史消息数
+	history     map[string]*ring[T] // 每个主题的历史消息, nil表示不保留
+
+	inbox uint64 // 最后分配的响应地址编号
+}
+
+func NewPublisher[T any](timeout time.Duration, buffer int) *Publisher[T] {
+	return &Publisher[T]{
+		buffer:      buffer,
+		timeout:     timeout,
+		subscribers: make(map[*Subscription[T]]struct{}),
+		broadcast:   make(map[*Subscription[T]]struct{}),
+		topics:      newTopicNode[T](),
+		epoch:       rand.Uint64() | 1, // 不为0, 0表示未知
+	}
+}
+
+// 增加一个新的订阅者, 订阅全部主题
+func (p *Publisher[T]) Subscribe(options ...SubscribeOption) (*Subscription[T], error) {
+	return p.SubscribeTopic(nil, options...)
+}
+
+// 增加新的订阅者, 订阅过滤后的主题
+func (p *Publisher[T]) SubscribeTopic(topic func(v T) bool, options ...SubscribeOption) (*Subscription[T], error) {
+	sub, opts := newSubscription(p, options)
+	sub.filter = topic
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed {
+		return nil, ErrPublisherClosed
+	}
+	p.add(sub, opts)
+	p.broadcast[sub] = struct{}{}
+	return sub, nil
+}
+
+// 增加新的订阅者, 订阅与pattern匹配的主题, 例如 "orders.created", "orders.*", "orders.>"
+func (p *Publisher[T]) SubscribePattern(pattern string, options ...SubscribeOption) (*Subscription[T], error) {
+	tokens, ok := splitTopic(pattern, true)
+	if !ok {
+		return nil, ErrInvalidTopic
+	}
+	sub, opts := newSubscription(p, options)
+	sub.pattern = tokens
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed {
+		return nil, ErrPublisherClosed
+	}
+	p.add(sub, opts)
+	p.topics.insert(tokens, sub)
+	return sub, nil
+}
+
+// 添加订阅者, 需要时开启分发协程. 持有写锁时没有正在发布的消息, 重放的历史消息与之后发布的消息是连续的.
+// 调用方需要持有写锁
+func (p *Publisher[T]) add(sub *Subscription[T], opts *subscribeOptions) {
+	p.subscribers[sub] = struct{}{}
+	sub.since = p.seq
+	if opts.replay != replayNone {
+		sub.queue = p.replay(sub, opts)
+	}
+	if sub.ordered {
+		go sub.dispatch(p.timeout)
+	}
+}
+
+// 结束订阅, 关闭管道. 已经结束的订阅忽略. 调用方需要持有写锁
+func (p *Publisher[T]) remove(sub *Subscription[T], reason error) {
+	if _, ok := p.subscribers[sub]; !ok {
+		return
+	}
+	delete(p.subscribers, sub)
+	if sub.pattern != nil {
+		p.topics.remove(sub.pattern, sub)
+	} else {
+		delete(p.broadcast, sub)
+	}
+	sub.stop(reason)
+}
+
+// 发布没有主题的消息. 发布者已经关闭时返回ErrPublisherClosed
+func (p *Publisher[T]) Publish(v T) error {
+	p.m.RLock()
+	if p.closed {
+		p.m.RUnlock()
+		return ErrPublisherClosed
+	}
+	slow := p.send(pickGroups(p.matchBroadcast(nil, v)), Envelope[T]{Value: v})
+	p.m.RUnlock()
+
+	p.disconnect(slow)
+	return nil
+}
+
+// 发布带有主题的消息, topic不能包含通配符
+func (p *Publisher[T]) PublishTopic(topic string, v T) error {
+	_, err := p.publish(topic, "", v)
+	return err
+}
+
+// 发布带有主题和响应地址的消息, 返回接收消息的订阅者数
+func (p *Publisher[T]) publish(topic, reply string, v T) (int, error) {
+	tokens, ok := splitTopic(topic, false)
+	if !ok {
+		return 0, ErrInvalidTopic
+	}
+
+	p.m.RLock()
+	if p.closed {
+		p.m.RUnlock()
+		return 0, ErrPublisherClosed
+	}
+	subs := p.matchBroadcast(nil, v)
+	p.topics.match(tokens, func(sub *Subscription[T]) {
+		subs = append(subs, sub)
+	})
+	subs = pickGroups(subs)
+	slow := p.send(subs, Envelope[T]{Topic: topic, Reply: reply, Value: v})
+	p.m.RUnlock()
+
+	p.disconnect(slow)
+	return len(subs), nil
+}
+
+// 关闭发布者, 结束所有订阅. 分发队列当中没有投递的消息被丢弃. 可以重复调用
+func (p *Publisher[T]) Close() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.closed = true
+	for sub := range p.subscribers {
+		p.remove(sub, ErrPublisherClosed)
+	}
+}
+
+// 订阅全部消息或者过滤函数接受该消息的订阅者
+func (p *Publisher[T]) matchBroadcast(subs []*Subscription[T], v T) []*Subscription[T] {
+	for sub := range p.broadcast {
+		if sub.filter == nil || sub.filter(v) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// 分配序列号, 向订阅者发送消息. 使用分发协程的订阅者放入队列; 其他订阅者先尝试不阻塞地发送,
+// 缓存已满时按照投递策略处理: PolicyBlock的订阅者共同等待最多timeout, 超时之后丢弃消息. 返回需要断开的订阅者
+func (p *Publisher[T]) send(subs []*Subscription[T], e Envelope[T]) (slow []*Subscription[T]) {
+	direct := subs[:0]
+	p.order.Lock()
+	p.seq++
+	e.Seq = p.seq
+	p.retain(e)
+	for _, sub := range subs {
+		if sub.ordered {
+			sub.enqueue(e)
+		} else {
+			direct = append(direct, sub)
+		}
+	}
+	p.order.Unlock()
+
+	var blocked []*Subscription[T]
+	for _, sub := range direct {
+		if sub.offer(e) {
+			continue
+		}
+		if !sub.overflow(e) {
+			blocked = append(blocked, sub)
+		} else if sub.policy == PolicyDisconnect {
+			slow = append(slow, sub)
+		}
+	}
+	if len(blocked) == 0 {
+		return slow
+	}
+
+	expired := make(chan struct{})
+	timer := time.AfterFunc(p.timeout, func() { close(expired) })
+	defer timer.Stop()
+
+	for _, sub := range blocked {
+		if !sub.wait(e, expired) {
+			sub.drop(e.Value)
+		}
+	}
+	return slow
+}
+
+// 断开消费过慢的订阅者. 需要写锁, 等待其他发布者结束发送之后再关闭管道
+func (p *Publisher[T]) disconnect(slow []*Subscription[T]) {
+	if len(slow) == 0 {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, sub := range slow {
+		p.remove(sub, ErrSlowConsumer) // 可能已经被其他发布者断开
+	}
+}
@@ -0,0 +1,314 @@
+package concurrent
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublisher(t *testing.T) {
+	p := NewPublisher[string](100*time.Millisecond, 10)
+	defer p.Close()
+
+	all, _ := p.Subscribe()
+	golang, _ := p.SubscribeTopic(func(v string) bool {
+		return strings.Contains(v, "golang")
+	})
+
+	p.Publish("Hello, world")
+	p.Publish("hello, golang")
+
+	go func() {
+		for msg := range all.C() {
+			fmt.Println("all:", msg)
+		}
+	}()
+
+	go func() {
+		for msg := range golang.C() {
+			fmt.Println("golang:", msg)
+		}
+	}()
+
+	time.Sleep(3 * time.Second)
+}
+
+func receive[T comparable](t *testing.T, sub *Subscription[T], want ...T) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case v := <-sub.C():
+			if v != w {
+				t.Fatalf("unexpected message: %v, want: %v", v, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %v is not received", w)
+		}
+	}
+	select {
+	case v := <-sub.C():
+		t.Fatalf("unexpected message: %v", v)
+	default:
+	}
+}
+
+func TestPublisherPattern(t *testing.T) {
+	p := NewPublisher[int](100*time.Millisecond, 10)
+	defer p.Close()
+
+	all, _ := p.Subscribe()
+	exact, _ := p.SubscribePattern("orders.created")
+	one, _ := p.SubscribePattern("orders.*")
+	rest, _ := p.SubscribePattern("orders.>")
+	middle, _ := p.SubscribePattern("*.eu.created")
+
+	p.PublishTopic("orders.created", 1)
+	p.PublishTopic("orders.eu.created", 2)
+	p.PublishTopic("users.created", 3)
+	p.Publish(4)
+
+	receive(t, all, 1, 2, 3, 4)
+	receive(t, exact, 1)
+	receive(t, one, 1)
+	receive(t, rest, 1, 2)
+	receive(t, middle, 2)
+
+	one.Unsubscribe()
+	p.PublishTopic("orders.created", 5)
+	receive(t, exact, 5)
+	if _, ok := <-one.C(); ok {
+		t.Fatal("unsubscribed subscription should be closed")
+	}
+}
+
+func TestPublisherInvalidTopic(t *testing.T) {
+	p := NewPublisher[int](100*time.Millisecond, 10)
+	defer p.Close()
+
+	for _, pattern := range []string{"", "orders.", ".orders", "orders.>.created", "orders.a*"} {
+		if _, err := p.SubscribePattern(pattern); err != ErrInvalidTopic {
+			t.Fatalf("pattern %q, unexpected error: %v", pattern, err)
+		}
+	}
+	for _, topic := range []string{"", "orders.*", "orders.>"} {
+		if err := p.PublishTopic(topic, 1); err != ErrInvalidTopic {
+			t.Fatalf("topic %q, unexpected error: %v", topic, err)
+		}
+	}
+}
+
+func TestPublisherTimeout(t *testing.T) {
+	p := NewPublisher[int](50*time.Millisecond, 1)
+	defer p.Close()
+
+	slow, _ := p.SubscribePattern("a")
+	fast, _ := p.SubscribePattern("a")
+	p.PublishTopic("a", 1)
+	<-fast.C()
+
+	start := time.Now()
+	p.PublishTopic("a", 2) // slow的缓存已满, 等待超时之后丢弃
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("publish should wait for timeout: %v", elapsed)
+	}
+	receive(t, slow, 1)
+	receive(t, fast, 2)
+}
+
+func TestDeliveryPolicy(t *testing.T) {
+	p := NewPublisher[int](time.Hour, 2)
+	defer p.Close()
+
+	var dropped []int
+	newest, _ := p.Subscribe(WithPolicy(PolicyDropNewest), p.OnDrop(func(v int) {
+		dropped = append(dropped, v)
+	}))
+	oldest, _ := p.Subscribe(WithPolicy(PolicyDropOldest))
+	slow, _ := p.Subscribe(WithPolicy(PolicyDisconnect))
+
+	for i := 1; i <= 4; i++ {
+		p.Publish(i) // 缓存已满时不会等待timeout
+	}
+
+	if newest.Dropped() != 2 || len(dropped) != 2 || dropped[0] != 3 || dropped[1] != 4 {
+		t.Fatalf("unexpected dropped: %d, %v", newest.Dropped(), dropped)
+	}
+	receive(t, newest, 1, 2)
+	if oldest.Dropped() != 2 {
+		t.Fatalf("unexpected dropped: %d", oldest.Dropped())
+	}
+	receive(t, oldest, 3, 4)
+
+	// 第三条消息时断开, 缓存当中的消息仍然可以读取
+	for _, want := range []int{1, 2} {
+		if v := <-slow.C(); v != want {
+			t.Fatalf("unexpected message: %v", v)
+		}
+	}
+	if _, ok := <-slow.C(); ok || slow.Err() != ErrSlowConsumer || slow.Dropped() != 1 {
+		t.Fatalf("slow subscription should be disconnected: %v, dropped: %d", slow.Err(), slow.Dropped())
+	}
+}
+
+func TestDeliveryPolicyBlockDrop(t *testing.T) {
+	p := NewPublisher[int](10*time.Millisecond, 1)
+	defer p.Close()
+
+	lost := make(chan int, 1)
+	sub, _ := p.Subscribe(p.OnDrop(func(v int) { lost <- v }))
+	p.Publish(1)
+	p.Publish(2)
+
+	if v := <-lost; v != 2 || sub.Dropped() != 1 {
+		t.Fatalf("unexpected dropped message: %v, count: %d", v, sub.Dropped())
+	}
+	receive(t, sub, 1)
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	p := NewPublisher[int](time.Second, 1)
+
+	sub, _ := p.Subscribe()
+	if sub.Err() != nil {
+		t.Fatalf("unexpected error: %v", sub.Err())
+	}
+	sub.Unsubscribe()
+	sub.Unsubscribe() // 重复取消不会panic
+	if sub.Err() != ErrUnsubscribed {
+		t.Fatalf("unexpected error: %v", sub.Err())
+	}
+
+	other, _ := p.Subscribe()
+	p.Close()
+	p.Close()
+	other.Unsubscribe() // 关闭之后取消不会panic
+	if _, ok := <-other.C(); ok || other.Err() != ErrPublisherClosed {
+		t.Fatalf("unexpected error: %v", other.Err())
+	}
+
+	if err := p.Publish(1); err != ErrPublisherClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.PublishTopic("a", 1); err != ErrPublisherClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := p.Subscribe(); err != ErrPublisherClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEnvelopes(t *testing.T) {
+	p := NewPublisher[string](time.Second, 10)
+	defer p.Close()
+
+	sub, _ := p.SubscribePattern("orders.>", WithEnvelopes())
+	if sub.C() != nil {
+		t.Fatal("C() should be nil for envelope subscription")
+	}
+	p.PublishTopic("orders.created", "a")
+	p.Publish("ignored")
+	p.PublishTopic("orders.paid", "b")
+
+	for _, want := range []Envelope[string]{
+		{Seq: 1, Topic: "orders.created", Value: "a"},
+		{Seq: 3, Topic: "orders.paid", Value: "b"},
+	} {
+		if e := <-sub.Envelopes(); e != want {
+			t.Fatalf("unexpected envelope: %+v, want: %+v", e, want)
+		}
+	}
+}
+
+func TestOrderedSubscription(t *testing.T) {
+	const (
+		publishers = 8
+		messages   = 200
+	)
+	p := NewPublisher[int](time.Second, 16)
+
+	subs := make([]*Subscription[int], 3)
+	for i := range subs {
+		subs[i], _ = p.Subscribe(WithOrdering(), WithEnvelopes())
+	}
+
+	// 并发发布, 每个订阅者收到的序列号顺序必须一致且递增
+	results := make([][]uint64, len(subs))
+	done := make(chan int)
+	for i, sub := range subs {
+		go func(i int, sub *Subscription[int]) {
+			for e := range sub.Envelopes() {
+				results[i] = append(results[i], e.Seq)
+				if len(results[i]) == publishers*messages {
+					break
+				}
+			}
+			done <- i
+		}(i, sub)
+	}
+	for i := 0; i < publishers; i++ {
+		go func() {
+			for j := 0; j < messages; j++ {
+				p.Publish(j)
+			}
+		}()
+	}
+	for range subs {
+		<-done
+	}
+	p.Close()
+
+	for i, seqs := range results {
+		if len(seqs) != publishers*messages {
+			t.Fatalf("subscriber %d, received: %d", i, len(seqs))
+		}
+		for j, seq := range seqs {
+			if seq != uint64(j+1) {
+				t.Fatalf("subscriber %d, message %d out of order: %d", i, j, seq)
+			}
+		}
+	}
+}
+
+func TestOrderedSubscriptionNonBlocking(t *testing.T) {
+	p := NewPublisher[int](time.Hour, 1)
+
+	slow, _ := p.Subscribe(WithOrdering())
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		p.Publish(i) // 放入分发队列, 不等待消费
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("publish should not be blocked: %v", elapsed)
+	}
+	for i := 0; i < 100; i++ {
+		if v := <-slow.C(); v != i {
+			t.Fatalf("unexpected message: %d, want: %d", v, i)
+		}
+	}
+
+	slow.Unsubscribe()
+	if _, ok := <-slow.C(); ok || slow.Err() != ErrUnsubscribed {
+		t.Fatalf("unexpected error: %v", slow.Err())
+	}
+	p.Close()
+}
+
+// 大量按照主题订阅的订阅者, 每条消息只匹配其中一个
+func BenchmarkPublishTopic(b *testing.B) {
+	p := NewPublisher[int](time.Second, 1)
+	defer p.Close()
+
+	const subscribers = 10000
+	subs := make([]*Subscription[int], subscribers)
+	for i := range subs {
+		subs[i], _ = p.SubscribePattern(fmt.Sprintf("orders.%d", i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % subscribers
+		p.PublishTopic(fmt.Sprintf("orders.%d", k), i)
+		<-subs[k].C()
+	}
+}
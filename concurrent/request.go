@@ -0,0 +1,102 @@
+package concurrent
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+请求/响应:
+	Request(topic, v, timeout) 为请求分配一个唯一的响应地址(inboxPrefix开头的主题), 订阅该地址之后发布请求,
+	等待第一个响应. 响应者使用WithEnvelopes()订阅, 通过Envelope.Reply得到响应地址, 调用Reply()回复.
+	没有订阅者接收请求时立即返回ErrNoResponders.
+
+队列组:
+	使用WithQueueGroup(name)订阅的订阅者组成队列组. 每条消息在同一个队列组的匹配的订阅者当中随机选择一个投递,
+	不属于任何队列组的订阅者不受影响. 可以与请求/响应结合, 由多个响应者分担请求.
+*/
+
+var (
+	ErrNoResponders   = errors.New("no responders for request")
+	ErrRequestTimeout = errors.New("request timeout")
+	ErrNoReply        = errors.New("message has no reply address")
+)
+
+// 响应地址的前缀
+const inboxPrefix = "_INBOX"
+
+// 加入队列组, 同一个队列组的订阅者每条消息只有一个收到
+func WithQueueGroup(name string) SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.group = name
+	}
+}
+
+// 发布请求并等待第一个响应, 超过timeout返回ErrRequestTimeout
+func (p *Publisher[T]) Request(topic string, v T, timeout time.Duration) (T, error) {
+	var zero T
+	inbox := inboxPrefix + "." + strconv.FormatUint(atomic.AddUint64(&p.inbox, 1), 10)
+	sub, err := p.SubscribePattern(inbox, WithEnvelopes())
+	if err != nil {
+		return zero, err
+	}
+	defer sub.Unsubscribe()
+
+	n, err := p.publish(topic, inbox, v)
+	if err != nil {
+		return zero, err
+	}
+	if n == 0 {
+		return zero, ErrNoResponders
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case e, ok := <-sub.Envelopes():
+		if !ok {
+			return zero, sub.Err()
+		}
+		return e.Value, nil
+	case <-timer.C:
+		return zero, ErrRequestTimeout
+	}
+}
+
+// 是否为响应地址. 响应只发给一个请求, 不保留为历史消息
+func isInbox(topic string) bool {
+	return strings.HasPrefix(topic, inboxPrefix+".")
+}
+
+// 回复请求, 消息没有响应地址时返回ErrNoReply
+func (p *Publisher[T]) Reply(req Envelope[T], v T) error {
+	if req.Reply == "" {
+		return ErrNoReply
+	}
+	return p.PublishTopic(req.Reply, v)
+}
+
+// 同一个队列组的订阅者只保留随机选择的一个, 复用subs的空间
+func pickGroups[T any](subs []*Subscription[T]) []*Subscription[T] {
+	var groups map[string][]*Subscription[T]
+	out := subs[:0]
+	for _, sub := range subs {
+		if sub.group == "" {
+			out = append(out, sub)
+			continue
+		}
+		if groups == nil {
+			groups = make(map[string][]*Subscription[T])
+		}
+		groups[sub.group] = append(groups[sub.group], sub)
+	}
+	for _, members := range groups {
+		out = append(out, members[rand.Intn(len(members))])
+	}
+	return out
+}
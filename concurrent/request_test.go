@@ -0,0 +1,172 @@
+package concurrent
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 把请求转换为大写之后回复
+func respond(p *Publisher[string], sub *Subscription[string]) {
+	for e := range sub.Envelopes() {
+		p.Reply(e, strings.ToUpper(e.Value))
+	}
+}
+
+func TestRequest(t *testing.T) {
+	p := NewPublisher[string](time.Second, 10)
+	defer p.Close()
+
+	sub, _ := p.SubscribePattern("echo.*", WithEnvelopes())
+	go respond(p, sub)
+
+	reply, err := p.Request("echo.upper", "hello", time.Second)
+	if err != nil || reply != "HELLO" {
+		t.Fatalf("unexpected reply: %q, error: %v", reply, err)
+	}
+
+	if _, err = p.Request("nobody", "hello", time.Second); err != ErrNoResponders {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = p.Request("echo.*", "hello", time.Second); err != ErrInvalidTopic {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = p.Reply(Envelope[string]{Value: "hello"}, "HELLO"); err != ErrNoReply {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 订阅者收到请求但是不回复
+	silent, _ := p.SubscribePattern("silent")
+	start := time.Now()
+	if _, err = p.Request("silent", "hello", 50*time.Millisecond); err != ErrRequestTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("request should wait for timeout: %v", elapsed)
+	}
+	receive(t, silent, "hello")
+}
+
+func TestRequestPublisherClosed(t *testing.T) {
+	p := NewPublisher[string](time.Second, 10)
+	sub, _ := p.SubscribePattern("a")
+
+	go func() {
+		<-sub.C()
+		p.Close() // 等待响应时关闭
+	}()
+	if _, err := p.Request("a", "hello", time.Second); err != ErrPublisherClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := p.Request("a", "hello", time.Second); err != ErrPublisherClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestQueueGroup(t *testing.T) {
+	const messages = 300
+	p := NewPublisher[int](time.Second, messages)
+	defer p.Close()
+
+	workers := make([]*Subscription[int], 3)
+	for i := range workers {
+		workers[i], _ = p.SubscribePattern("jobs.>", WithQueueGroup("workers"))
+	}
+	other, _ := p.SubscribePattern("jobs.*", WithQueueGroup("audit"))
+	all, _ := p.Subscribe()
+
+	for i := 0; i < messages; i++ {
+		p.PublishTopic("jobs.created", i)
+	}
+
+	// 每条消息在workers当中只投递一次, 其他队列组和普通订阅者不受影响
+	seen := make(map[int]bool)
+	for i, w := range workers {
+		if len(w.C()) == 0 {
+			t.Fatalf("worker %d receives nothing", i)
+		}
+		for len(w.C()) > 0 {
+			v := <-w.C()
+			if seen[v] {
+				t.Fatalf("message %d is delivered twice", v)
+			}
+			seen[v] = true
+		}
+	}
+	if len(seen) != messages || len(other.C()) != messages || len(all.C()) != messages {
+		t.Fatalf("unexpected received: %d, %d, %d", len(seen), len(other.C()), len(all.C()))
+	}
+}
+
+func TestQueueGroupRequest(t *testing.T) {
+	p := NewPublisher[string](time.Second, 10)
+	defer p.Close()
+
+	var lock sync.Mutex
+	handled := make(map[int]int)
+	for i := 0; i < 3; i++ {
+		sub, _ := p.SubscribePattern("rpc", WithQueueGroup("responders"), WithEnvelopes())
+		go func(i int) {
+			for e := range sub.Envelopes() {
+				lock.Lock()
+				handled[i]++
+				lock.Unlock()
+				p.Reply(e, e.Value)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 30; i++ {
+		if _, err := p.Request("rpc", "ping", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	total := 0
+	for _, n := range handled {
+		total += n
+	}
+	if total != 30 {
+		t.Fatalf("each request should be handled once: %v", handled)
+	}
+}
+
+func TestRequestHistory(t *testing.T) {
+	p := NewPublisher[string](time.Second, 10)
+	defer p.Close()
+	p.SetHistory(16)
+
+	sub, _ := p.SubscribePattern("echo.*", WithEnvelopes())
+	go respond(p, sub)
+
+	for i := 0; i < 100; i++ {
+		if _, err := p.Request("echo.upper", "hello", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 响应不保留为历史消息, 从头重放的订阅者只收到请求
+	p.order.Lock()
+	for topic := range p.history {
+		if isInbox(topic) {
+			p.order.Unlock()
+			t.Fatalf("reply is retained: %s", topic)
+		}
+	}
+	p.order.Unlock()
+
+	all, _ := p.Subscribe(FromSeq(1), WithEnvelopes())
+	for i := 0; i < 16; i++ {
+		e := <-all.Envelopes()
+		if e.Topic != "echo.upper" {
+			t.Fatalf("unexpected replayed envelope: %+v", e)
+		}
+	}
+	select {
+	case e := <-all.Envelopes():
+		t.Fatalf("unexpected replayed envelope: %+v", e)
+	default:
+	}
+}
@@ -0,0 +1,341 @@
+package concurrent
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DeliveryPolicy 订阅者的缓存已满时的投递策略
+type DeliveryPolicy int
+
+const (
+	PolicyBlock      DeliveryPolicy = iota // 等待最多timeout, 超时之后丢弃新消息
+	PolicyDropNewest                       // 立即丢弃新消息
+	PolicyDropOldest                       // 丢弃缓存当中最旧的消息, 放入新消息
+	PolicyDisconnect                       // 丢弃新消息, 并断开订阅者(关闭管道)
+)
+
+// Envelope 投递的消息及其元数据
+type Envelope[T any] struct {
+	Seq   uint64 // 发布者分配的序列号, 从1开始递增, 反映消息的发布顺序
+	Topic string // 消息的主题, Publish()发布的消息为空
+	Value T
+	Reply string // 响应地址, 只有Request()发布的消息不为空
+}
+
+// subscribeOptions 订阅的配置
+type subscribeOptions struct {
+	policy    DeliveryPolicy
+	onDrop    interface{} // func(v T), 由Publisher[T].OnDrop()设置
+	ordered   bool
+	envelopes bool
+	group     string // 队列组
+
+	replay    replayMode // 订阅时重放的历史消息
+	replayN   int
+	replaySeq uint64
+}
+
+// SubscribeOption 订阅的选项
+type SubscribeOption func(opts *subscribeOptions)
+
+// 设置投递策略, 默认为PolicyBlock
+func WithPolicy(policy DeliveryPolicy) SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.policy = policy
+	}
+}
+
+// 设置消息被丢弃时的回调, 在发布者(或者分发协程)当中同步执行. 回调的参数类型在编译时与Publisher的消息类型一致
+func (p *Publisher[T]) OnDrop(fn func(v T)) SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.onDrop = fn
+	}
+}
+
+// 使用独立的分发协程和无界队列投递消息. 发布者只需要把消息放入队列, 不会被该订阅者阻塞;
+// 所有开启该选项的订阅者, 按照相同的顺序(序列号递增)收到消息. 投递策略在分发协程当中执行
+func WithOrdering() SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.ordered = true
+	}
+}
+
+// 通过Envelopes()接收带有序列号和主题的消息, 此时C()返回nil
+func WithEnvelopes() SubscribeOption {
+	return func(opts *subscribeOptions) {
+		opts.envelopes = true
+	}
+}
+
+//-------------------------------------------------------------------------
+
+// Subscription 订阅的句柄
+type Subscription[T any] struct {
+	publisher *Publisher[T]
+	ch        chan T           // 接收消息, 与env二选一
+	env       chan Envelope[T] // 接收带有元数据的消息
+	filter    func(v T) bool   // 过滤函数, nil表示不过滤
+	pattern   []string         // 订阅的主题, nil表示不按照主题订阅
+	group     string           // 队列组, 空表示不属于任何队列组
+	since     uint64           // 订阅时最后分配的序列号, 之后发布的消息都会被匹配
+
+	policy  DeliveryPolicy
+	onDrop  func(v T)
+	dropped uint64 // 丢弃的消息数
+
+	ordered bool          // 是否使用分发协程
+	queue   []Envelope[T] // 等待分发的消息
+	stopped bool          // 订阅已经结束, 分发协程退出
+	quit    chan struct{} // 订阅结束时关闭, 唤醒阻塞在投递当中的分发协程
+	qlock   sync.Mutex    // 保护queue和stopped
+	qcond   *sync.Cond
+
+	lock sync.Mutex
+	err  error // 订阅结束的原因
+}
+
+func newSubscription[T any](p *Publisher[T], options []SubscribeOption) (*Subscription[T], *subscribeOptions) {
+	var opts subscribeOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	sub := &Subscription[T]{
+		publisher: p,
+		policy:    opts.policy,
+		group:     opts.group,
+		ordered:   opts.ordered || opts.replay != replayNone,
+	}
+	if opts.envelopes {
+		sub.env = make(chan Envelope[T], p.buffer)
+	} else {
+		sub.ch = make(chan T, p.buffer)
+	}
+	if opts.onDrop != nil {
+		onDrop, ok := opts.onDrop.(func(v T))
+		if !ok { // 只有把其他消息类型的Publisher创建的选项传给该Publisher时才会发生
+			panic(fmt.Sprintf("concurrent: OnDrop option of %T is used by Publisher of another message type", opts.onDrop))
+		}
+		sub.onDrop = onDrop
+	}
+	if sub.ordered {
+		sub.quit = make(chan struct{})
+		sub.qcond = sync.NewCond(&sub.qlock)
+	}
+	return sub, &opts
+}
+
+// 接收消息的管道, 订阅结束之后被关闭. 使用WithEnvelopes()订阅时返回nil
+func (sub *Subscription[T]) C() <-chan T {
+	return sub.ch
+}
+
+// 接收带有序列号和主题的消息的管道, 订阅结束之后被关闭. 没有使用WithEnvelopes()订阅时返回nil
+func (sub *Subscription[T]) Envelopes() <-chan Envelope[T] {
+	return sub.env
+}
+
+// 取消订阅, 可以重复调用
+func (sub *Subscription[T]) Unsubscribe() {
+	p := sub.publisher
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.remove(sub, ErrUnsubscribed)
+}
+
+// 订阅结束的原因: ErrUnsubscribed, ErrPublisherClosed 或者 ErrSlowConsumer. 订阅没有结束时返回nil
+func (sub *Subscription[T]) Err() error {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	return sub.err
+}
+
+// 丢弃的消息数
+func (sub *Subscription[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// 订阅是否接收该主题的消息, 不检查过滤函数
+func (sub *Subscription[T]) matchTopic(topic string) bool {
+	if sub.pattern == nil {
+		return true
+	}
+	tokens, ok := splitTopic(topic, false)
+	return ok && matchPattern(sub.pattern, tokens)
+}
+
+// 结束订阅. 使用分发协程时由分发协程关闭管道, 否则直接关闭. 调用方需要持有发布者的写锁
+func (sub *Subscription[T]) stop(reason error) {
+	sub.lock.Lock()
+	sub.err = reason
+	sub.lock.Unlock()
+
+	if !sub.ordered {
+		sub.close()
+		return
+	}
+	sub.qlock.Lock()
+	sub.stopped = true
+	sub.queue = nil
+	sub.qcond.Signal()
+	sub.qlock.Unlock()
+	close(sub.quit)
+}
+
+func (sub *Subscription[T]) close() {
+	if sub.env != nil {
+		close(sub.env)
+	} else {
+		close(sub.ch)
+	}
+}
+
+// 不阻塞地投递消息, 缓存已满返回false
+func (sub *Subscription[T]) offer(e Envelope[T]) bool {
+	if sub.env != nil {
+		select {
+		case sub.env <- e:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case sub.ch <- e.Value:
+		return true
+	default:
+		return false
+	}
+}
+
+// 阻塞地投递消息, expired关闭或者订阅结束时返回false
+func (sub *Subscription[T]) wait(e Envelope[T], expired <-chan struct{}) bool {
+	if sub.env != nil {
+		select {
+		case sub.env <- e:
+			return true
+		case <-expired:
+		case <-sub.quit:
+		}
+		return false
+	}
+	select {
+	case sub.ch <- e.Value:
+		return true
+	case <-expired:
+	case <-sub.quit:
+	}
+	return false
+}
+
+// 丢弃缓存当中最旧的消息
+func (sub *Subscription[T]) evict() {
+	if sub.env != nil {
+		select {
+		case old := <-sub.env:
+			sub.drop(old.Value)
+		default:
+		}
+		return
+	}
+	select {
+	case old := <-sub.ch:
+		sub.drop(old)
+	default:
+	}
+}
+
+// 丢弃消息
+func (sub *Subscription[T]) drop(v T) {
+	atomic.AddUint64(&sub.dropped, 1)
+	if sub.onDrop != nil {
+		sub.onDrop(v)
+	}
+}
+
+// 缓存已满时按照投递策略处理, 返回false表示需要等待(PolicyBlock)
+func (sub *Subscription[T]) overflow(e Envelope[T]) bool {
+	switch sub.policy {
+	case PolicyDropOldest:
+		for sub.buffered() {
+			sub.evict()
+			if sub.offer(e) {
+				return true
+			}
+			// 其他发布者占用了空出的位置, 重试
+		}
+		sub.drop(e.Value)
+	case PolicyDropNewest, PolicyDisconnect:
+		sub.drop(e.Value)
+	default:
+		return false
+	}
+	return true
+}
+
+func (sub *Subscription[T]) buffered() bool {
+	return cap(sub.ch) > 0 || cap(sub.env) > 0
+}
+
+// 放入分发队列
+func (sub *Subscription[T]) enqueue(e Envelope[T]) {
+	sub.qlock.Lock()
+	if !sub.stopped {
+		sub.queue = append(sub.queue, e)
+		sub.qcond.Signal()
+	}
+	sub.qlock.Unlock()
+}
+
+// 从分发队列取出消息, 订阅结束时返回false
+func (sub *Subscription[T]) dequeue() (Envelope[T], bool) {
+	sub.qlock.Lock()
+	defer sub.qlock.Unlock()
+
+	for len(sub.queue) == 0 && !sub.stopped {
+		sub.qcond.Wait()
+	}
+	if sub.stopped {
+		return Envelope[T]{}, false
+	}
+	e := sub.queue[0]
+	sub.queue[0] = Envelope[T]{}
+	sub.queue = sub.queue[1:]
+	return e, true
+}
+
+// 分发协程: 按照顺序投递队列当中的消息, 订阅结束之后关闭管道
+func (sub *Subscription[T]) dispatch(timeout time.Duration) {
+	defer sub.close()
+
+	for {
+		e, ok := sub.dequeue()
+		if !ok {
+			return
+		}
+		if sub.offer(e) {
+			continue
+		}
+		if sub.overflow(e) {
+			if sub.policy == PolicyDisconnect {
+				sub.publisher.disconnect([]*Subscription[T]{sub})
+			}
+			continue
+		}
+
+		expired := make(chan struct{})
+		timer := time.AfterFunc(timeout, func() { close(expired) })
+		if !sub.wait(e, expired) {
+			select {
+			case <-sub.quit: // 订阅已经结束, 不计入丢弃
+			default:
+				sub.drop(e.Value)
+			}
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,125 @@
+package concurrent
+
+import (
+	"strings"
+)
+
+/*
+主题:
+	主题是使用 "." 分隔的若干个单词, 例如 "orders.created". 订阅时可以使用通配符:
+	"*" 匹配一个单词, 例如 "orders.*" 匹配 "orders.created", 不匹配 "orders.eu.created";
+	">" 只能出现在最后, 匹配剩余的一个或多个单词, 例如 "orders.>" 匹配 "orders.eu.created".
+
+	订阅按照单词构建成前缀树, 发布时只沿着主题的单词查找, 与订阅者的数量无关.
+*/
+
+const (
+	topicSeparator = "."
+	wildcardOne    = "*"
+	wildcardRest   = ">"
+)
+
+// 拆分主题. pattern为true时允许通配符
+func splitTopic(topic string, pattern bool) ([]string, bool) {
+	if topic == "" {
+		return nil, false
+	}
+	tokens := strings.Split(topic, topicSeparator)
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return nil, false
+		case token == wildcardOne:
+			if !pattern {
+				return nil, false
+			}
+		case token == wildcardRest:
+			if !pattern || i != len(tokens)-1 {
+				return nil, false
+			}
+		case strings.ContainsAny(token, wildcardOne+wildcardRest):
+			return nil, false
+		}
+	}
+	return tokens, true
+}
+
+// 主题是否与订阅的pattern匹配
+func matchPattern(pattern, tokens []string) bool {
+	for i, token := range pattern {
+		if token == wildcardRest {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (token != wildcardOne && token != tokens[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(tokens)
+}
+
+// topicNode 主题前缀树的节点
+type topicNode[T any] struct {
+	children map[string]*topicNode[T]
+	subs     map[*Subscription[T]]struct{} // 在该节点结束的订阅
+}
+
+func newTopicNode[T any]() *topicNode[T] {
+	return &topicNode[T]{
+		children: make(map[string]*topicNode[T]),
+		subs:     make(map[*Subscription[T]]struct{}),
+	}
+}
+
+func (n *topicNode[T]) empty() bool {
+	return len(n.children) == 0 && len(n.subs) == 0
+}
+
+// 添加订阅
+func (n *topicNode[T]) insert(tokens []string, sub *Subscription[T]) {
+	for _, token := range tokens {
+		child, ok := n.children[token]
+		if !ok {
+			child = newTopicNode[T]()
+			n.children[token] = child
+		}
+		n = child
+	}
+	n.subs[sub] = struct{}{}
+}
+
+// 删除订阅, 并删除不再使用的节点
+func (n *topicNode[T]) remove(tokens []string, sub *Subscription[T]) {
+	if len(tokens) == 0 {
+		delete(n.subs, sub)
+		return
+	}
+	child, ok := n.children[tokens[0]]
+	if !ok {
+		return
+	}
+	child.remove(tokens[1:], sub)
+	if child.empty() {
+		delete(n.children, tokens[0])
+	}
+}
+
+// 查找与主题匹配的所有订阅, 调用fn
+func (n *topicNode[T]) match(tokens []string, fn func(sub *Subscription[T])) {
+	if len(tokens) == 0 {
+		for sub := range n.subs {
+			fn(sub)
+		}
+		return
+	}
+	if rest, ok := n.children[wildcardRest]; ok {
+		for sub := range rest.subs {
+			fn(sub)
+		}
+	}
+	if child, ok := n.children[wildcardOne]; ok {
+		child.match(tokens[1:], fn)
+	}
+	if child, ok := n.children[tokens[0]]; ok {
+		child.match(tokens[1:], fn)
+	}
+}
@@ -1,6 +1,7 @@
 package waitgroup
 
 import (
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -43,7 +44,44 @@ type WaitGroup struct {
 	// 在32位机器上, 中4*8位作为计数器, 低4*8位作为goroutine的等待数. (高4位空置)
 	// 在64位机器上, slice的对齐为8, 在32位机器上slice对齐应该为4
 	state1 [12]byte
-	sema   uint32
+	sema   semaphore
+}
+
+/*
+semaphore 代替runtime的信号量(runtime_Semacquire/runtime_Semrelease):
+	release() 计数加1, 唤醒最早的一个等待者; acquire() 计数大于0时减1返回, 否则排队休眠.
+	与runtime一样, 被唤醒的等待者需要重新获取计数, 计数可能先被其他调用acquire()的goroutine拿走,
+	这时Wait()可以检测到 "WaitGroup is reused before previous Wait has returned". 零值可以直接使用.
+*/
+type semaphore struct {
+	lock    sync.Mutex
+	count   uint32
+	waiters []chan struct{}
+}
+
+func (s *semaphore) acquire() {
+	s.lock.Lock()
+	for s.count == 0 {
+		wakeup := make(chan struct{})
+		s.waiters = append(s.waiters, wakeup)
+		s.lock.Unlock()
+		<-wakeup
+		s.lock.Lock()
+	}
+	s.count--
+	s.lock.Unlock()
+}
+
+func (s *semaphore) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.count++
+	if len(s.waiters) > 0 {
+		close(s.waiters[0])
+		s.waiters[0] = nil
+		s.waiters = s.waiters[1:]
+	}
 }
 
 /*
@@ -99,18 +137,23 @@ func (wg *WaitGroup) Add(delta int) {
 	// 此时不可能同时发生的状态突变:
 	// - Add()不能与Wait()同时发生
 	// - 如果计数器为0, 不再增加等待数
-	if *statep != state {
+	if atomic.LoadUint64(statep) != state {
 		panic("sync: WaitGroup misuse: Add called concurrently with Wait")
 	}
 
 	// Reset waiters count to 0.
-	*statep = 0
+	atomic.StoreUint64(statep, 0)
 	for ; w != 0; w-- {
-		// 目的是一个简单的wakeup原语, 以供同步使用. true为唤醒排在等待队列的第一个goroutine
-		// runtime_Semrelease(&wg.sema, false)
+		// 唤醒一个等待的goroutine, 代替 runtime_Semrelease(&wg.sema, false)
+		wg.sema.release()
 	}
 }
 
+// 计数器减1
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
 func (wg *WaitGroup) Wait() {
 	statep := wg.state()
 
@@ -126,9 +169,9 @@ func (wg *WaitGroup) Wait() {
 
 		// 增加等待的goroutine数量, 对低32为数加1
 		if atomic.CompareAndSwapUint64(statep, state, state+1) {
-			// 目的是一个简单的sleep原语, 以供同步使用
-			// runtime_Semacquire(&wg.sema)
-			if *statep != 0 {
+			// 休眠直到计数器归零, 代替 runtime_Semacquire(&wg.sema)
+			wg.sema.acquire()
+			if atomic.LoadUint64(statep) != 0 {
 				panic("sync: WaitGroup is reused before previous Wait has returned")
 			}
 			return
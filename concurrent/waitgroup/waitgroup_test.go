@@ -3,7 +3,6 @@ package waitgroup
 import (
 	"fmt"
 	"runtime"
-	"sync"
 	"sync/atomic"
 	"testing"
 )
@@ -14,7 +13,7 @@ import (
 	wg1: 每个协程等待 wg2 的完成, 一旦完成,则发送一个退出信号
 	wg2: 测试 wg1 当中发出的退出信号
 */
-func testWaitGroup(t *testing.T, wg1 *sync.WaitGroup, wg2 *sync.WaitGroup) {
+func testWaitGroup(t *testing.T, wg1 *WaitGroup, wg2 *WaitGroup) {
 	n := 16
 	exited := make(chan bool, n)
 	wg1.Add(n)
@@ -42,8 +41,8 @@ func testWaitGroup(t *testing.T, wg1 *sync.WaitGroup, wg2 *sync.WaitGroup) {
 
 // 死锁检查
 func TestWaitGroup(t *testing.T) {
-	wg1 := &sync.WaitGroup{}
-	wg2 := &sync.WaitGroup{}
+	wg1 := &WaitGroup{}
+	wg2 := &WaitGroup{}
 
 	// Run the same test a few times to ensure barrier is in a proper state.
 	for i := 0; i != 8; i++ {
@@ -75,7 +74,7 @@ func TestWaitGroupMisuse(t *testing.T) {
 			t.Fatalf("Unexpected panic: %#v", err)
 		}
 	}()
-	wg := &sync.WaitGroup{}
+	wg := &WaitGroup{}
 	wg.Add(1)
 	wg.Done()
 	wg.Done() // 抛出异常
@@ -107,7 +106,7 @@ func TestWaitGroupMisuse2(t *testing.T) {
 
 	// 这种检测是带有机会性的, 期待在一次运行l00万协程的状况下发生异常
 	for i := 0; i < 1e6; i++ {
-		var wg sync.WaitGroup
+		var wg WaitGroup
 		var here uint32
 		wg.Add(1)
 		go func() {
@@ -169,7 +168,7 @@ func TestWaitGroupMisuse3(t *testing.T) {
 
 	// 这种检测是带有机会性的, 期待在一次运行l00万协程的状况下发生异常
 	for i := 0; i < 1; i++ {
-		var wg sync.WaitGroup
+		var wg WaitGroup
 		wg.Add(1)
 
 		go func() {
@@ -206,7 +205,7 @@ func TestWaitGroupMisuse3(t *testing.T) {
 
 func BenchmarkWaitGroupUncontended(b *testing.B) {
 	type PaddedWaitGroup struct {
-		sync.WaitGroup
+		WaitGroup
 		pad [128]uint8
 	}
 	b.RunParallel(func(pb *testing.PB) {
@@ -221,7 +220,7 @@ func BenchmarkWaitGroupUncontended(b *testing.B) {
 
 // 压测: Add() 与 Done(), 使用一个wg
 func benchmarkWaitGroupAddDone(b *testing.B, localWork int) {
-	var wg sync.WaitGroup
+	var wg WaitGroup
 	b.RunParallel(func(pb *testing.PB) {
 		foo := 0
 		for pb.Next() {
@@ -246,7 +245,7 @@ func BenchmarkWaitGroupAddDoneWork(b *testing.B) {
 
 // 压测: Wait(), 使用一个wg
 func benchmarkWaitGroupWait(b *testing.B, localWork int) {
-	var wg sync.WaitGroup
+	var wg WaitGroup
 	b.RunParallel(func(pb *testing.PB) {
 		foo := 0
 		for pb.Next() {
@@ -273,7 +272,7 @@ func BenchmarkWaitGroupActuallyWait(b *testing.B) {
 	b.ReportAllocs()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			var wg sync.WaitGroup
+			var wg WaitGroup
 			wg.Add(1)
 			go func() {
 				wg.Done()
@@ -0,0 +1,153 @@
+package pool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// AutoscaleConfig 自动伸缩的配置.
+// 任务的平均等待时长超过WaitThreshold, 或者排队的任务数超过QueueThreshold时扩容;
+// 连续ShrinkRounds个周期没有排队的任务, 且空闲的Worker不少于Step时缩容; 负载稳定时Worker短暂的空闲不会触发缩容.
+// 容量始终保持在[MinCap, MaxCap]之间.
+type AutoscaleConfig struct {
+	MinCap int // 容量的下限
+	MaxCap int // 容量的上限
+
+	Interval time.Duration // 检查的周期
+
+	WaitThreshold  time.Duration // 任务平均等待时长的阈值, 0表示不检查
+	QueueThreshold int           // 排队任务数的阈值, 0表示不检查
+
+	Step int // 每次伸缩的数量, 默认为1
+
+	ShrinkRounds int // 连续空闲多少个周期之后缩容, 默认为3
+
+	OnScale func(ScaleDecision) // 每次伸缩之后回调, 可以为nil
+}
+
+// ScaleDecision 一次伸缩的决策
+type ScaleDecision struct {
+	OldCap int
+	NewCap int
+
+	AvgWait  time.Duration // 检查周期内任务的平均等待时长
+	QueueLen int           // 检查时排队的任务数
+	Idle     int           // 检查时空闲的Worker数量
+}
+
+const defaultShrinkRounds = 3
+
+// 开启自动伸缩. 重复调用会替换之前的配置
+func (p *Pool) Autoscale(config AutoscaleConfig) error {
+	if config.MinCap <= 0 || config.MaxCap < config.MinCap {
+		return ErrInvalidPoolSize
+	}
+	if config.Interval <= 0 || p.Cap() == -1 { // 不限容量的Pool不需要伸缩
+		return ErrInvalidAutoscale
+	}
+	if config.Step <= 0 {
+		config.Step = 1
+	}
+	if config.ShrinkRounds <= 0 {
+		config.ShrinkRounds = defaultShrinkRounds
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.IsClosed() {
+		return ErrPoolClosed
+	}
+	if p.stopAutoscale != nil {
+		p.stopAutoscale()
+	}
+	p.autoscale = &config
+	p.startAutoscale()
+	return nil
+}
+
+// 开启自动伸缩的协程. 调用方需要持有锁
+func (p *Pool) startAutoscale() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopAutoscale = cancel
+	go p.periodicallyScale(ctx, *p.autoscale)
+}
+
+func (p *Pool) periodicallyScale(ctx context.Context, config AutoscaleConfig) {
+	// 容量调整到[MinCap, MaxCap]之间
+	if capacity := clamp(p.Cap(), config.MinCap, config.MaxCap); capacity != p.Cap() {
+		p.ResetCap(capacity)
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	idleRounds := 0 // 连续空闲的周期数
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if decision, ok := p.decideScale(config, &idleRounds); ok {
+			p.ResetCap(decision.NewCap)
+			if config.OnScale != nil {
+				config.OnScale(decision)
+			}
+		}
+	}
+}
+
+// 根据周期内的等待时长, 排队任务数和空闲Worker数量做出伸缩的决策. 容量不变时返回false.
+// idleRounds记录连续空闲的周期数, 缩容之后重新计数
+func (p *Pool) decideScale(config AutoscaleConfig, idleRounds *int) (ScaleDecision, bool) {
+	var avgWait time.Duration
+	total := atomic.SwapInt64(&p.metrics.windowWaitTotal, 0)
+	if count := atomic.SwapInt64(&p.metrics.windowWaitCount, 0); count > 0 {
+		avgWait = time.Duration(total / count)
+	}
+
+	p.lock.Lock()
+	decision := ScaleDecision{
+		OldCap:   p.Cap(),
+		AvgWait:  avgWait,
+		QueueLen: p.queue.len(),
+		Idle:     p.idleWorkers.len(),
+	}
+	p.lock.Unlock()
+
+	overloaded := (config.WaitThreshold > 0 && decision.AvgWait > config.WaitThreshold) ||
+		(config.QueueThreshold > 0 && decision.QueueLen > config.QueueThreshold)
+
+	idle := !overloaded && decision.QueueLen == 0 && decision.Idle >= config.Step
+	if idle {
+		*idleRounds++
+	} else {
+		*idleRounds = 0
+	}
+
+	switch {
+	case overloaded:
+		decision.NewCap = decision.OldCap + config.Step
+	case idle && *idleRounds >= config.ShrinkRounds:
+		*idleRounds = 0
+		decision.NewCap = decision.OldCap - config.Step
+	default:
+		decision.NewCap = decision.OldCap
+	}
+	decision.NewCap = clamp(decision.NewCap, config.MinCap, config.MaxCap)
+
+	return decision, decision.NewCap != decision.OldCap
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
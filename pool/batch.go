@@ -0,0 +1,123 @@
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+// 批量提交NewJob()创建的任务, 返回的Handle与jobs一一对应.
+// 任意任务提交失败时取消已经提交的任务, 返回错误
+func (p *Pool) SubmitBatch(jobs []Job) ([]*Handle, error) {
+	tasks := make([]*job, len(jobs))
+	for i, j := range jobs {
+		task, ok := j.(*job)
+		if !ok || task == nil {
+			return nil, ErrFunction
+		}
+		tasks[i] = task
+	}
+
+	handles := make([]*Handle, 0, len(tasks))
+	for _, task := range tasks {
+		h, err := p.Submit(task)
+		if err != nil {
+			for _, h := range handles {
+				h.Cancel()
+			}
+			return nil, err
+		}
+		handles = append(handles, h)
+	}
+	return handles, nil
+}
+
+// 使用Pool并发地对items执行fn, 结果按照items的顺序返回.
+// 任意fn返回错误或者panic时, 取消其余的任务(没有开始的不再执行, 正在执行的通过ctx得到通知), 返回第一个错误
+func Map[T, R any](p *Pool, items []T, fn func(ctx context.Context, item T) (R, error)) ([]R, error) {
+	results, errs, err := mapItems(p, items, fn, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// 与Map相同, 但是错误不会中止其他任务. 返回每个item的结果和错误, 提交失败时返回的错误不为nil
+func MapAll[T, R any](p *Pool, items []T, fn func(ctx context.Context, item T) (R, error)) ([]R, []error, error) {
+	return mapItems(p, items, fn, false)
+}
+
+// 使用Pool并发地对items执行fn, 任意fn返回错误或者panic时取消其余的任务, 返回第一个错误
+func ForEach[T any](p *Pool, items []T, fn func(ctx context.Context, item T) error) error {
+	_, err := Map(p, items, func(ctx context.Context, item T) (struct{}, error) {
+		return struct{}{}, fn(ctx, item)
+	})
+	return err
+}
+
+func mapItems[T, R any](p *Pool, items []T, fn func(ctx context.Context, item T) (R, error), stopOnError bool) ([]R, []error, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		results = make([]R, len(items))
+		errs    = make([]error, len(items))
+		first   sync.Once // 第一个错误, 用于区分被中止的任务
+		stopped = -1
+	)
+	stop := func(i int) {
+		if stopOnError {
+			first.Do(func() {
+				stopped = i
+				cancel()
+			})
+		}
+	}
+
+	handles := make([]*Handle, 0, len(items))
+	for i := range items {
+		i := i
+		job, err := NewJob(func(ctx context.Context) {
+			if results[i], errs[i] = fn(ctx, items[i]); errs[i] != nil {
+				stop(i)
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := p.submit(ctx, job, PriorityNormal, func(err error) {
+			if err != nil && err != context.Canceled { // panic
+				stop(i)
+			}
+		})
+		if err == context.Canceled && ctx.Err() != nil { // 已经有任务失败, 不再提交
+			break
+		}
+		if err != nil {
+			cancel()
+			for _, h := range handles {
+				h.Wait()
+			}
+			return nil, nil, err
+		}
+		handles = append(handles, h)
+	}
+
+	for i, h := range handles {
+		if err := h.Wait(); err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+	}
+	if stopped >= 0 { // 只保留第一个错误, 其余的任务是被中止的
+		err := errs[stopped]
+		for i := range errs {
+			errs[i] = nil
+		}
+		errs[stopped] = err
+	}
+	return results, errs, nil
+}
@@ -2,37 +2,78 @@ package pool
 
 import (
 	"errors"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	// DefaultPoolSize 是Pool默认的capacity
 	DefaultPoolSize = 10000
 
-	// DefaultCleanInterval 是pool默认的情况清理时间
-	DefaultCleanInterval = 5
+	// DefaultCleanInterval 是pool默认的清理时间
+	DefaultCleanInterval = 5 * time.Second
+
+	// DefaultQueueSize 是Pool任务队列默认的容量
+	DefaultQueueSize = 1024
+
+	// Shutdown() 检查Worker是否全部退出的时间间隔
+	shutdownPollInterval = 10 * time.Millisecond
 )
 
-// 默认的Pool
-var defaultPool, _ = NewPool(DefaultPoolSize)
+// 默认的Pool, 类型为*Pool
+var defaultPool atomic.Value
+
+func init() {
+	p, _ := NewPool(DefaultPoolSize)
+	defaultPool.Store(p)
+}
+
+// 默认的Pool. 包级别的函数都使用该Pool
+func Default() *Pool {
+	return defaultPool.Load().(*Pool)
+}
+
+// 使用配置替换默认的Pool, 原来的Pool被关闭, 已经提交的任务继续执行.
+// 应当在程序启动时, 提交任务之前调用
+func SetDefault(capacity int, options ...Option) error {
+	p, err := New(capacity, options...)
+	if err != nil {
+		return err
+	}
+	defaultPool.Swap(p).(*Pool).Close()
+	return nil
+}
 
-func Submit(task *job) error {
-	return defaultPool.Submit(task)
+func Submit(task *job) (*Handle, error) {
+	return Default().Submit(task)
+}
+
+func SubmitWithPriority(task *job, priority Priority) (*Handle, error) {
+	return Default().SubmitWithPriority(task, priority)
+}
+
+func SubmitBatch(tasks []Job) ([]*Handle, error) {
+	return Default().SubmitBatch(tasks)
 }
 
 func Running() int {
-	return defaultPool.Running()
+	return Default().Running()
 }
 
 func Cap() int {
-	return defaultPool.Cap()
+	return Default().Cap()
 }
 
 func Idle() int {
-	return defaultPool.Idle()
+	return Default().Idle()
+}
+
+func Free() int {
+	return Default().Free()
 }
 
 func Close() {
-	defaultPool.Close()
+	Default().Close()
 }
 
 var (
@@ -41,4 +82,16 @@ var (
 	ErrPoolClosed        = errors.New("this pool has been closed")
 	ErrFunction          = errors.New("function type is invalid")
 	ErrFunctionArgs      = errors.New("function args is invalid")
+	ErrInvalidPriority   = errors.New("invalid priority for job")
+	ErrInvalidAutoscale  = errors.New("invalid autoscale config for pool")
+	ErrInvalidRateLimit  = errors.New("invalid rate limit")
+	ErrRateLimited       = errors.New("submit rate limit exceeded")
+	ErrInvalidCron       = errors.New("invalid cron expression")
+	ErrScheduleOverrun   = errors.New("previous scheduled submit is still blocked")
+	ErrJobPanicked       = errors.New("job panicked")
+	ErrJobNotRegistered  = errors.New("job is not registered")
+	ErrCorruptedQueue    = errors.New("queue log is corrupted")
+
+	ErrPoolOverload         = errors.New("task queue of the pool is full")
+	ErrInvalidBlockingTasks = errors.New("max blocking tasks must be non-negative and cannot be set in nonblocking mode")
 )
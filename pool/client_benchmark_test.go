@@ -115,3 +115,120 @@ func BenchmarkPool(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+// 高并发提交短任务, 衡量getWorker/putWorker路径上锁的开销
+const contentionPoolSize = 64
+
+func cheapFunc() {}
+
+func BenchmarkGoroutineContention(b *testing.B) {
+	var wg sync.WaitGroup
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			go func() {
+				cheapFunc()
+				wg.Done()
+			}()
+		}
+	})
+	wg.Wait()
+}
+
+func BenchmarkSemaphoreContention(b *testing.B) {
+	var wg sync.WaitGroup
+	sema := make(chan struct{}, contentionPoolSize)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			sema <- struct{}{}
+			go func() {
+				cheapFunc()
+				<-sema
+				wg.Done()
+			}()
+		}
+	})
+	wg.Wait()
+}
+
+func BenchmarkPoolContention(b *testing.B) {
+	var wg sync.WaitGroup
+	p, _ := pool.NewPool(contentionPoolSize)
+	defer p.Close()
+
+	job, _ := pool.NewJob(func() {
+		cheapFunc()
+		wg.Done()
+	})
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			p.Submit(job)
+		}
+	})
+	wg.Wait()
+}
+
+const fibN = 25
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+// 递归的任务: 规模较小时直接计算, 避免任务粒度过细
+func forkFib(s *pool.Scope, n int) int {
+	if n < 15 {
+		return fib(n)
+	}
+	var x int
+	s.Fork(func(s *pool.Scope) { x = forkFib(s, n-1) })
+	y := forkFib(s, n-2)
+	s.Join()
+	return x + y
+}
+
+func goFib(n int) int {
+	if n < 15 {
+		return fib(n)
+	}
+	var (
+		wg sync.WaitGroup
+		x  int
+	)
+	wg.Add(1)
+	go func() {
+		x = goFib(n - 1)
+		wg.Done()
+	}()
+	y := goFib(n - 2)
+	wg.Wait()
+	return x + y
+}
+
+func BenchmarkFibSequential(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		fib(fibN)
+	}
+}
+
+func BenchmarkFibGoroutine(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		goFib(fibN)
+	}
+}
+
+func BenchmarkFibStealingPool(b *testing.B) {
+	sp, _ := pool.NewStealingPool(0)
+	defer sp.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h, _ := sp.Go(func(s *pool.Scope) { forkFib(s, fibN) })
+		h.Wait()
+	}
+}
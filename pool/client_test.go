@@ -1,10 +1,20 @@
 package pool_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang/pool"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -49,19 +59,22 @@ func TestNoPool(t *testing.T) {
 
 func TestPool(t *testing.T) {
 	defer pool.Close()
-	var wg sync.WaitGroup
-	for i := 0; i < n; i++ {
-		wg.Add(1)
-		job, _ := pool.NewJob(
+	jobs := make([]pool.Job, n)
+	for i := range jobs {
+		jobs[i], _ = pool.NewJob(
 			func() error {
 				demoFunc()
-				wg.Done()
 				return nil
 			},
 		)
-		pool.Submit(job)
 	}
-	wg.Wait()
+	handles, err := pool.SubmitBatch(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range handles {
+		h.Wait()
+	}
 
 	t.Logf("pool, capacity:%d", pool.Cap())
 	t.Logf("pool, running workers number:%d", pool.Running())
@@ -88,7 +101,7 @@ func TestCodeCov(t *testing.T) {
 	}
 	t.Logf("pool, capacity:%d", p0.Cap())
 	t.Logf("pool, running workers number:%d", p0.Running())
-	t.Logf("pool, free workers number:%d", p0.Idle())
+	t.Logf("pool, free workers number:%d", p0.Free())
 	p0.ResetCap(PoolSize)
 	p0.ResetCap(PoolSize / 2)
 	t.Logf("pool, after resize, capacity:%d, running:%d", p0.Cap(), p0.Running())
@@ -99,11 +112,1760 @@ func TestCodeCov(t *testing.T) {
 	for i := 0; i < n; i++ {
 		p.Submit(job)
 	}
-	time.Sleep(pool.DefaultCleanInterval * time.Second)
+	time.Sleep(pool.DefaultCleanInterval)
 	t.Logf("pool with func, capacity:%d", p.Cap())
 	t.Logf("pool with func, running workers number:%d", p.Running())
-	t.Logf("pool with func, free workers number:%d", p.Idle())
+	t.Logf("pool with func, free workers number:%d", p.Free())
 	p.ResetCap(TestSize)
 	p.ResetCap(PoolSize)
 	t.Logf("pool with func, after resize, capacity:%d, running:%d", p.Cap(), p.Running())
 }
+
+// 阻塞唯一的Worker, 使后续提交的任务全部进入队列
+func blockPool(t *testing.T, p *pool.Pool) chan struct{} {
+	block := make(chan struct{})
+	job, _ := pool.NewJob(func() { <-block })
+	if _, err := p.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestPriorityFIFO(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	block := blockPool(t, p)
+
+	var (
+		lock  sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	record := func(i int) {
+		lock.Lock()
+		order = append(order, i)
+		lock.Unlock()
+		wg.Done()
+	}
+
+	// 低优先级: 0,1,2  高优先级: 10,11,12, 交替提交
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		low, _ := pool.NewJob(record, i)
+		high, _ := pool.NewJob(record, 10+i)
+		p.SubmitWithPriority(low, pool.PriorityLow)
+		p.SubmitWithPriority(high, pool.PriorityHigh)
+	}
+	if n := p.QueueLen(pool.PriorityLow); n != 3 {
+		t.Fatalf("low priority queue length: %d", n)
+	}
+	if n := p.QueueLen(pool.PriorityHigh); n != 3 {
+		t.Fatalf("high priority queue length: %d", n)
+	}
+	if n := p.Queued(); n != 6 {
+		t.Fatalf("queue length: %d", n)
+	}
+
+	close(block)
+	wg.Wait()
+
+	expect := []int{10, 11, 12, 0, 1, 2}
+	for i := range expect {
+		if order[i] != expect[i] {
+			t.Fatalf("unexpected order: %v", order)
+		}
+	}
+}
+
+func TestPriorityStarvation(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	block := blockPool(t, p)
+
+	var (
+		lock  sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	record := func(i int) {
+		lock.Lock()
+		order = append(order, i)
+		lock.Unlock()
+		wg.Done()
+	}
+
+	wg.Add(1)
+	low, _ := pool.NewJob(record, -1)
+	p.SubmitWithPriority(low, pool.PriorityLow)
+	const highs = 50
+	for i := 0; i < highs; i++ {
+		wg.Add(1)
+		high, _ := pool.NewJob(record, i)
+		p.SubmitWithPriority(high, pool.PriorityHigh)
+	}
+
+	close(block)
+	wg.Wait()
+
+	// 低优先级的任务不能等到所有高优先级任务执行完成之后才执行
+	for i, v := range order {
+		if v == -1 {
+			if i == highs {
+				t.Fatalf("low priority job starved: %v", order)
+			}
+			return
+		}
+	}
+	t.Fatal("low priority job lost")
+}
+
+func TestInvalidPriority(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	job, _ := pool.NewJob(demoFunc)
+	if _, err := p.SubmitWithPriority(job, pool.Priority(100)); err != pool.ErrInvalidPriority {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	p, _ := pool.NewPool(2)
+
+	var (
+		lock     sync.Mutex
+		finished int
+	)
+	job, _ := pool.NewJob(func() {
+		time.Sleep(20 * time.Millisecond)
+		lock.Lock()
+		finished++
+		lock.Unlock()
+	})
+	for i := 0; i < 10; i++ {
+		p.Submit(job)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if finished != 10 {
+		t.Fatalf("finished jobs: %d", finished)
+	}
+	if n := p.Running(); n != 0 {
+		t.Fatalf("running workers after shutdown: %d", n)
+	}
+	if _, err := p.Submit(job); err != pool.ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	block := blockPool(t, p)
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReboot(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	p.Close()
+	job, _ := pool.NewJob(demoFunc)
+	if _, err := p.Submit(job); err != pool.ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.Reboot()
+	if p.IsClosed() {
+		t.Fatal("pool is still closed after reboot")
+	}
+	if _, err := p.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitWhileClose(t *testing.T) {
+	p, _ := pool.NewPool(10)
+	job, _ := pool.NewJob(demoFunc)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Submit(job); err != nil && err != pool.ErrPoolClosed {
+				t.Error(err)
+			}
+		}()
+	}
+	p.Close()
+	wg.Wait()
+}
+
+func TestPurgeExpiredWorkers(t *testing.T) {
+	p, _ := pool.NewTimingPool(10, 20*time.Millisecond)
+	defer p.Close()
+
+	job, _ := pool.NewJob(func() {})
+	for i := 0; i < 10; i++ {
+		p.Submit(job)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for p.Running() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle workers are not purged, running: %d", p.Running())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 只清理过期的空闲Worker, 最近放回的Worker保留
+func TestPurgePartialExpiry(t *testing.T) {
+	p, _ := pool.NewTimingPool(4, 200*time.Millisecond)
+	defer p.Close()
+
+	// 同时执行n个任务, 使用n个不同的Worker
+	use := func(n int) {
+		block := make(chan struct{})
+		job, _ := pool.NewJob(func() { <-block })
+		for i := 0; i < n; i++ {
+			p.Submit(job)
+		}
+		close(block)
+		for p.Idle() < p.Running() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	use(4)
+	time.Sleep(250 * time.Millisecond) // 第二次清理(400ms)时, 前两个Worker过期, 后两个保留
+	use(2)
+
+	deadline := time.Now().Add(time.Second)
+	for p.Running() == 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle workers are not purged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if running := p.Running(); running != 2 {
+		t.Fatalf("unexpected running workers after purge: %d", running)
+	}
+}
+
+// 关闭时仍然有Worker在执行任务, 清理协程和Worker协程都必须退出
+func TestCloseGoroutineLeak(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	p, _ := pool.NewTimingPool(4, 10*time.Millisecond)
+	block := make(chan struct{})
+	job, _ := pool.NewJob(func() { <-block })
+	for i := 0; i < 4; i++ {
+		p.Submit(job)
+	}
+	p.Close()
+	close(block)
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine leak: before %d, after %d", base, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 不加锁取出和放回空闲Worker的路径与入队, 缩容, 清理和关闭并发, 任务不丢失, Worker不泄漏
+func TestIdleWorkersConcurrent(t *testing.T) {
+	const (
+		submitters = 8
+		jobs       = 2000
+	)
+	base := runtime.NumGoroutine()
+	p, _ := pool.NewTimingPool(4, 5*time.Millisecond)
+
+	var done int64
+	job, _ := pool.NewJob(func() { atomic.AddInt64(&done, 1) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < submitters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < jobs; j++ {
+				if _, err := p.Submit(job); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		p.ResetCap(1 + i%4)
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&done) != submitters*jobs {
+		if time.Now().After(deadline) {
+			t.Fatalf("lost jobs: %d of %d completed, queued: %d", atomic.LoadInt64(&done), submitters*jobs, p.Queued())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v, running: %d", err, p.Running())
+	}
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine leak: before %d, after %d", base, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResetCapNonBlocking(t *testing.T) {
+	p, _ := pool.NewPool(4)
+	defer p.Close()
+
+	block := make(chan struct{})
+	job, _ := pool.NewJob(func() { <-block })
+	for i := 0; i < 4; i++ {
+		p.Submit(job)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.ResetCap(1) // 所有的Worker都在执行任务, 缩容不能阻塞
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ResetCap blocked")
+	}
+
+	close(block)
+	deadline := time.Now().Add(time.Second)
+	for p.Running() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("running workers after shrink: %d", p.Running())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAutoscale(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	var (
+		lock      sync.Mutex
+		decisions []pool.ScaleDecision
+	)
+	err := p.Autoscale(pool.AutoscaleConfig{
+		MinCap:         1,
+		MaxCap:         4,
+		Interval:       10 * time.Millisecond,
+		QueueThreshold: 1,
+		OnScale: func(d pool.ScaleDecision) {
+			lock.Lock()
+			decisions = append(decisions, d)
+			lock.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := make(chan struct{})
+	job, _ := pool.NewJob(func() { <-block })
+	for i := 0; i < 8; i++ {
+		p.Submit(job)
+	}
+
+	waitFor := func(cond func() bool, msg string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(func() bool { return p.Cap() == 4 }, "pool is not scaled up")
+	close(block)
+	waitFor(func() bool { return p.Cap() == 1 }, "pool is not scaled down")
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(decisions) == 0 || decisions[0].NewCap <= decisions[0].OldCap {
+		t.Fatalf("unexpected decisions: %+v", decisions)
+	}
+}
+
+// 只有连续多个周期都有空闲的Worker时才缩容
+func TestAutoscaleSustainedIdle(t *testing.T) {
+	p, _ := pool.NewPool(4)
+	defer p.Close()
+
+	block := make(chan struct{})
+	job, _ := pool.NewJob(func() { <-block })
+	for i := 0; i < 4; i++ {
+		p.Submit(job)
+	}
+	close(block)
+	for p.Idle() < 4 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const interval = 20 * time.Millisecond
+	scaled := make(chan time.Time, 4)
+	start := time.Now()
+	p.Autoscale(pool.AutoscaleConfig{
+		MinCap:       1,
+		MaxCap:       4,
+		Interval:     interval,
+		ShrinkRounds: 3,
+		OnScale:      func(pool.ScaleDecision) { scaled <- time.Now() },
+	})
+
+	select {
+	case at := <-scaled:
+		if elapsed := at.Sub(start); elapsed < 3*interval-5*time.Millisecond {
+			t.Fatalf("pool is scaled down after %v, before 3 idle intervals", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pool is not scaled down")
+	}
+	if c := p.Cap(); c != 3 {
+		t.Fatalf("unexpected capacity: %d", c)
+	}
+}
+
+func TestAutoscaleInvalid(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	if err := p.Autoscale(pool.AutoscaleConfig{MinCap: 2, MaxCap: 1, Interval: time.Second}); err != pool.ErrInvalidPoolSize {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.Autoscale(pool.AutoscaleConfig{MinCap: 1, MaxCap: 2}); err != pool.ErrInvalidAutoscale {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	p, _ := pool.NewPool(2)
+	defer p.Close()
+
+	ok, _ := pool.NewJob(func() {
+		time.Sleep(time.Millisecond)
+	})
+	bad, _ := pool.NewJob(func() {
+		panic("bad job")
+	})
+	var jobs []pool.Job
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, ok, bad)
+	}
+	handles, err := p.SubmitBatch(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range handles {
+		h.Wait()
+	}
+
+	// 统计在Handle结束之前完成, Worker在之后放回空闲栈
+	deadline := time.Now().Add(time.Second)
+	s := p.Stats()
+	for s.Idle != s.Running {
+		if time.Now().After(deadline) {
+			t.Fatalf("all workers should be idle: %+v", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+		s = p.Stats()
+	}
+	if s.Submitted != 20 || s.Completed != 10 || s.Panicked != 10 || s.Capacity != 2 || s.Queued != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if s.AvgExec <= 0 || s.ExecTotal < 10*time.Millisecond || s.WaitCount != 20 {
+		t.Fatalf("unexpected time stats: %+v", s)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	p, _ := pool.NewPool(2)
+	defer p.Close()
+
+	job, _ := pool.NewJob(func() {})
+	h, _ := p.Submit(job)
+	h.Wait()
+
+	w := httptest.NewRecorder()
+	p.MetricsHandler("test").ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	for _, line := range []string{
+		"# TYPE pool_submitted_total counter",
+		`pool_submitted_total{pool="test"} 1`,
+		`pool_capacity{pool="test"} 2`,
+		"# TYPE pool_wait_seconds_sum counter",
+		`pool_wait_seconds_count{pool="test"} 1`,
+		"# TYPE pool_exec_seconds_count counter",
+		`pool_exec_seconds_count{pool="test"} 1`,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("metrics %q not found in:\n%s", line, body)
+		}
+	}
+}
+
+func TestKeyedPool(t *testing.T) {
+	p, _ := pool.NewPool(8)
+	defer p.Close()
+	kp := pool.NewKeyedPool(p)
+
+	const keys, jobs = 10, 100
+	var (
+		lock   sync.Mutex
+		orders = make(map[int][]int)
+		wg     sync.WaitGroup
+	)
+	record := func(key, i int) {
+		time.Sleep(time.Microsecond)
+		lock.Lock()
+		orders[key] = append(orders[key], i)
+		lock.Unlock()
+		wg.Done()
+	}
+	for i := 0; i < jobs; i++ {
+		for key := 0; key < keys; key++ {
+			wg.Add(1)
+			job, _ := pool.NewJob(record, key, i)
+			if err := kp.Submit(key, job); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for key := 0; key < keys; key++ {
+		order := orders[key]
+		if len(order) != jobs {
+			t.Fatalf("key %d, executed jobs: %d", key, len(order))
+		}
+		for i, v := range order {
+			if v != i {
+				t.Fatalf("key %d, unexpected order: %v", key, order)
+			}
+		}
+	}
+
+	// 执行完成之后, key的队列被回收
+	deadline := time.Now().Add(time.Second)
+	for kp.Keys() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle key queues are not cleaned: %d", kp.Keys())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKeyedPoolPanic(t *testing.T) {
+	recovered := make(chan interface{}, 1)
+	p, _ := pool.New(1, pool.WithPanicHandler(func(r interface{}) { recovered <- r }))
+	defer p.Close()
+	var (
+		lock  sync.Mutex
+		names []string
+	)
+	p.SetHooks(pool.Hooks{
+		AfterExecute: func(ctx context.Context, job pool.Job, d time.Duration, r interface{}) {
+			lock.Lock()
+			names = append(names, fmt.Sprint(r))
+			lock.Unlock()
+		},
+	})
+	kp := pool.NewKeyedPool(p)
+
+	done := make(chan struct{})
+	bad, _ := pool.NewJob(func() { panic("bad job") })
+	ok, _ := pool.NewJob(func() { close(done) })
+	kp.Submit("key", bad)
+	kp.Submit("key", ok)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job after panic is not executed")
+	}
+	if r := <-recovered; r != "bad job" {
+		t.Fatalf("unexpected recovered value: %v", r)
+	}
+
+	// 每个任务回调一次AfterExecute, 执行队列的runner本身不回调
+	deadline := time.Now().Add(time.Second)
+	for {
+		lock.Lock()
+		got := strings.Join(names, ",")
+		lock.Unlock()
+		if got == "bad job,<nil>" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected AfterExecute calls: %q", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 任务的执行超时对KeyedPool同样有效
+func TestKeyedPoolJobTimeout(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	kp := pool.NewKeyedPool(p)
+
+	result := make(chan error, 1)
+	job, _ := pool.NewJob(func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			result <- ctx.Err()
+		case <-time.After(time.Second):
+			result <- nil
+		}
+	})
+	if err := kp.Submit("key", job.WithTimeout(20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// runner被Pool拒绝时, 在同一个队列当中排队的任务全部返回错误, 不会被静默丢弃
+func TestKeyedPoolSubmitRejected(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	release := fillPool(t, p)
+	defer close(release)
+	kp := pool.NewKeyedPool(p)
+
+	var executed int32
+	job, _ := pool.NewJob(func() { atomic.AddInt32(&executed, 1) })
+	errs := make(chan error, 2)
+	go func() { errs <- kp.Submit("key", job) }() // runner阻塞在已满的队列
+	for p.Stats().Waiters != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() { errs <- kp.Submit("key", job) }() // 排在同一个队列, 等待runner的提交结果
+	select {
+	case err := <-errs:
+		t.Fatalf("submit should wait for runner: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	p.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != pool.ErrPoolClosed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if kp.Keys() != 0 || atomic.LoadInt32(&executed) != 0 {
+		t.Fatalf("rejected jobs are kept, keys: %d, executed: %d", kp.Keys(), executed)
+	}
+}
+
+// fakeClock 可控的时钟, 只有调用Advance()时间才会流逝
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.waiters)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func TestLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l, _ := pool.NewLimiter(10, 2, clock)
+
+	if !l.Allow() || !l.Allow() {
+		t.Fatal("burst tokens are not available")
+	}
+	if l.Allow() {
+		t.Fatal("tokens exceed burst")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !l.Allow() {
+		t.Fatal("token is not refilled")
+	}
+
+	if _, err := pool.NewLimiter(0, 1, clock); err != pool.ErrInvalidRateLimit {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPoolRateLimitReject(t *testing.T) {
+	p, _ := pool.NewPool(10)
+	defer p.Close()
+	l, _ := pool.NewLimiter(1, 1, newFakeClock())
+	p.SetRateLimit(l, 0)
+
+	job, _ := pool.NewJob(func() {})
+	if _, err := p.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Submit(job); err != pool.ErrRateLimited {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPoolRateLimitDelay(t *testing.T) {
+	p, _ := pool.NewPool(10)
+	defer p.Close()
+	clock := newFakeClock()
+	l, _ := pool.NewLimiter(10, 1, clock)
+	p.SetRateLimit(l, time.Second)
+
+	job, _ := pool.NewJob(func() {})
+	p.Submit(job)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Submit(job)
+		done <- err
+	}()
+
+	// 等待Submit()阻塞在限流器上
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("submit is not delayed")
+	default:
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyedPoolRateLimit(t *testing.T) {
+	p, _ := pool.NewPool(10)
+	defer p.Close()
+	kp := pool.NewKeyedPool(p)
+	kp.SetKeyRateLimit(1, 1, 0, newFakeClock())
+
+	job, _ := pool.NewJob(func() {})
+	if err := kp.Submit("a", job); err != nil {
+		t.Fatal(err)
+	}
+	if err := kp.Submit("a", job); err != pool.ErrRateLimited {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 不同的key使用独立的限流器
+	if err := kp.Submit("b", job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Pool的限流对每个任务生效, 包括排在已有队列当中的任务
+func TestKeyedPoolPoolRateLimit(t *testing.T) {
+	p, _ := pool.NewPool(10)
+	defer p.Close()
+	l, _ := pool.NewLimiter(1, 3, newFakeClock())
+	p.SetRateLimit(l, 0)
+	kp := pool.NewKeyedPool(p)
+
+	block := make(chan struct{})
+	defer close(block)
+	first, _ := pool.NewJob(func() { <-block })
+	job, _ := pool.NewJob(func() {})
+	if err := kp.Submit("a", first); err != nil { // key的队列一直在执行
+		t.Fatal(err)
+	}
+	accepted := 1
+	for i := 0; i < 9; i++ {
+		switch err := kp.Submit("a", job); err {
+		case nil:
+			accepted++
+		case pool.ErrRateLimited:
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if accepted != 3 {
+		t.Fatalf("accepted jobs exceed burst: %d", accepted)
+	}
+	if _, err := p.Submit(job); err != pool.ErrRateLimited {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSubmitAfter(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	done := make(chan time.Time, 1)
+	job, _ := pool.NewJob(func() { done <- time.Now() })
+	start := time.Now()
+	timer, err := p.SubmitAfter(50*time.Millisecond, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timer.Handle() != nil {
+		t.Fatal("handle should be nil before due")
+	}
+	select {
+	case at := <-done:
+		if at.Sub(start) < 50*time.Millisecond {
+			t.Fatalf("job executed too early: %v", at.Sub(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delayed job is not executed")
+	}
+
+	<-timer.Done()
+	if timer.Err() != nil || timer.Handle().Wait() != nil {
+		t.Fatalf("unexpected error: %v", timer.Err())
+	}
+	if timer.Cancel() {
+		t.Fatal("cancel after submit should fail")
+	}
+}
+
+// 到期时提交失败, 错误通过Timer返回; 已经提交的任务可以通过Handle()取消
+func TestSubmitAfterError(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	job, _ := pool.NewJob(func(ctx context.Context) { <-ctx.Done() })
+
+	running, _ := p.SubmitAfter(0, job)
+	<-running.Done()
+	if running.Cancel() {
+		t.Fatal("cancel after submit should fail")
+	}
+	running.Handle().Cancel()
+	if err := running.Handle().Wait(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	closed, _ := p.SubmitAfter(20*time.Millisecond, job)
+	p.Close()
+	select {
+	case <-closed.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timer is not finished after pool closed")
+	}
+	if closed.Err() != pool.ErrPoolClosed || closed.Handle() != nil {
+		t.Fatalf("unexpected error: %v", closed.Err())
+	}
+}
+
+func TestSubmitAtCancel(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	done := make(chan struct{}, 1)
+	job, _ := pool.NewJob(func() { done <- struct{}{} })
+	timer, _ := p.SubmitAt(time.Now().Add(50*time.Millisecond), job)
+	if !timer.Cancel() {
+		t.Fatal("cancel before due should succeed")
+	}
+	if timer.Cancel() {
+		t.Fatal("cancel twice should fail")
+	}
+	<-timer.Done()
+	select {
+	case <-done:
+		t.Fatal("canceled job is executed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2020, time.January, 1, 10, 30, 0, 0, time.UTC) // 周三
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * 1", time.Date(2020, 1, 6, 8, 30, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2020, 1, 5, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5,10 10 1,15 1 *", time.Date(2020, 1, 15, 10, 5, 0, 0, time.UTC)},
+		{"0 0 15 * 5", time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},      // 日和周满足其一
+		{"20/1 10 * * *", time.Date(2020, 1, 1, 10, 31, 0, 0, time.UTC)}, // 显式的步长1表示从20到59
+		{"0 9 * * MON-FRI", time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 12 * * sun", time.Date(2020, 1, 5, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 Feb,DEC *", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := pool.ParseCron(c.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", c.spec, err)
+		}
+		if next := s.Next(base); !next.Equal(c.next) {
+			t.Fatalf("%q: expect %v, got %v", c.spec, c.next, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "JAN * * * *", "* * * FOO *", "* * * * MON-XYZ"} {
+		if _, err := pool.ParseCron(spec); err != pool.ErrInvalidCron {
+			t.Fatalf("%q: unexpected error: %v", spec, err)
+		}
+	}
+
+	// 不存在的日期
+	s, _ := pool.ParseCron("0 0 30 2 *")
+	if next := s.Next(base); !next.IsZero() {
+		t.Fatalf("unexpected next time: %v", next)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	job, _ := pool.NewJob(func() {})
+	if _, err := p.Schedule("* * *", job); err != pool.ErrInvalidCron {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timer, err := p.Schedule("*/5 * * * *", job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !timer.Cancel() {
+		t.Fatal("cancel before due should succeed")
+	}
+	<-timer.Done()
+
+	p.Close()
+	if _, err := p.Schedule("* * * * *", job); err != pool.ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHandleWait(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	ok, _ := pool.NewJob(func() {})
+	h, _ := p.Submit(ok)
+	if err := h.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	bad, _ := pool.NewJob(func() { panic("bad job") })
+	h, _ = p.Submit(bad)
+	if err := h.Wait(); err != pool.ErrJobPanicked {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	block := blockPool(t, p)
+
+	executed := make(chan struct{}, 1)
+	job, _ := pool.NewJob(func() { executed <- struct{}{} })
+	h, _ := p.Submit(job)
+	if h.Err() != nil {
+		t.Fatal("pending job should not have an error")
+	}
+	h.Cancel()
+	if err := h.Wait(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	close(block)
+	// 取消的任务被跳过, 后续的任务正常执行
+	next, _ := pool.NewJob(func() {})
+	h, _ = p.Submit(next)
+	h.Wait()
+	select {
+	case <-executed:
+		t.Fatal("canceled job is executed")
+	default:
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	started := make(chan struct{})
+	job, _ := pool.NewJob(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+	h, _ := p.Submit(job)
+	<-started
+	h.Cancel()
+	if err := h.Wait(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	job, _ := pool.NewJob(func(ctx context.Context, d time.Duration) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
+	}, time.Second)
+	h, _ := p.Submit(job.WithTimeout(20 * time.Millisecond))
+	if err := h.Wait(); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSubmitContextCanceledWhileBlocked(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	block := blockPool(t, p)
+	defer close(block)
+
+	// 填满任务队列
+	job, _ := pool.NewJob(func() {})
+	for i := 0; i < pool.DefaultQueueSize; i++ {
+		p.Submit(job)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.SubmitContext(ctx, job, pool.PriorityNormal); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	q := pool.NewMemoryQueue()
+	for i := 0; i < 3; i++ {
+		q.Append(&pool.Record{Name: "job"})
+	}
+	q.Ack(2)
+	records, _ := q.Pending()
+	if len(records) != 2 || records[0].ID != 1 || records[1].ID != 3 {
+		t.Fatalf("unexpected pending records: %+v", records)
+	}
+}
+
+func TestFileQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := pool.OpenFileQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		q.Append(&pool.Record{Name: "job"})
+	}
+	q.Ack(1)
+	q.Close()
+
+	// 模拟崩溃时写入了不完整的一行
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"op":"app`)
+	file.Close()
+
+	q, err = pool.OpenFileQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	records, _ := q.Pending()
+	if len(records) != 2 || records[0].ID != 2 || records[1].ID != 3 {
+		t.Fatalf("unexpected pending records: %+v", records)
+	}
+	rec := &pool.Record{Name: "job"}
+	q.Append(rec)
+	if rec.ID != 4 {
+		t.Fatalf("unexpected record id: %d", rec.ID)
+	}
+}
+
+// 崩溃时最后一行完整但是缺少换行符, 之后的追加不能写在同一行
+func TestFileQueueMissingNewline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, _ := pool.OpenFileQueue(path)
+	q.Append(&pool.Record{Name: "job"})
+	q.Append(&pool.Record{Name: "job"})
+	q.Close()
+
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+
+	for i := 0; i < 2; i++ {
+		q, err := pool.OpenFileQueue(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			q.Append(&pool.Record{Name: "job"})
+		}
+		records, _ := q.Pending()
+		q.Close()
+		if len(records) != 3 || records[2].ID != 3 {
+			t.Fatalf("unexpected pending records: %+v", records)
+		}
+	}
+}
+
+func TestDurablePoolReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+
+	var (
+		lock sync.Mutex
+		sum  int
+	)
+	add := func(name string, n int) {
+		lock.Lock()
+		sum += n
+		lock.Unlock()
+	}
+
+	// 任务持久化之后进程崩溃, 任务没有执行
+	q, _ := pool.OpenFileQueue(path)
+	q.Append(&pool.Record{Name: "add", Args: []json.RawMessage{[]byte(`"a"`), []byte(`1`)}})
+	q.Append(&pool.Record{Name: "add", Args: []json.RawMessage{[]byte(`"b"`), []byte(`2`)}})
+	q.Close()
+
+	// 重启之后重放
+	q, _ = pool.OpenFileQueue(path)
+	p, _ := pool.NewPool(2)
+	defer p.Close()
+	d := pool.NewDurablePool(p, q)
+	d.Register("add", add)
+	if _, err := d.Submit("missing"); err != pool.ErrJobNotRegistered {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h, err := d.Submit("add", "c", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+	if n, err := d.Replay(); err != nil || n != 2 {
+		t.Fatalf("replay: %d, %v", n, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		records, _ := q.Pending()
+		if len(records) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs are not acked: %+v", records)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	q.Close()
+
+	if sum != 6 {
+		t.Fatalf("unexpected sum: %d", sum)
+	}
+	q, _ = pool.OpenFileQueue(path)
+	defer q.Close()
+	if records, _ := q.Pending(); len(records) != 0 {
+		t.Fatalf("finished jobs are replayed: %+v", records)
+	}
+}
+
+// 本进程提交的任务还没有结束时, Replay()不会重复提交
+func TestDurablePoolReplayInFlight(t *testing.T) {
+	q := pool.NewMemoryQueue()
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+	d := pool.NewDurablePool(p, q)
+
+	var executed int32
+	block := make(chan struct{})
+	d.Register("block", func() {
+		atomic.AddInt32(&executed, 1)
+		<-block
+	})
+	h1, _ := d.Submit("block") // 执行当中
+	h2, _ := d.Submit("block") // 排队当中
+	for i := 0; i < 2; i++ {
+		if n, err := d.Replay(); err != nil || n != 0 {
+			t.Fatalf("replay: %d, %v", n, err)
+		}
+	}
+	close(block)
+	h1.Wait()
+	h2.Wait()
+
+	if n := atomic.LoadInt32(&executed); n != 2 {
+		t.Fatalf("unexpected executed jobs: %d", n)
+	}
+	if records, _ := q.Pending(); len(records) != 0 {
+		t.Fatalf("finished jobs are pending: %+v", records)
+	}
+}
+
+// 提交失败的任务不保留在队列当中, 重启之后不会被重放
+func TestDurablePoolSubmitRejected(t *testing.T) {
+	q := pool.NewMemoryQueue()
+	p, _ := pool.NewPool(1)
+	d := pool.NewDurablePool(p, q)
+	d.Register("noop", func() {})
+	p.Close()
+
+	if _, err := d.Submit("noop"); err != pool.ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if records, _ := q.Pending(); len(records) != 0 {
+		t.Fatalf("rejected job is pending: %+v", records)
+	}
+}
+
+type traceKey struct{}
+
+func traceJob(ctx context.Context) {
+	if ctx.Value(traceKey{}) != "span" {
+		panic("trace span is not propagated")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	p, _ := pool.NewTimingPool(1, 20*time.Millisecond)
+	defer p.Close()
+
+	var (
+		lock      sync.Mutex
+		events    []string
+		recovered []interface{}
+		exited    = make(chan struct{})
+	)
+	record := func(e string) {
+		lock.Lock()
+		events = append(events, e)
+		lock.Unlock()
+	}
+	p.SetHooks(pool.Hooks{
+		BeforeExecute: func(ctx context.Context, job pool.Job) context.Context {
+			record("before")
+			return context.WithValue(ctx, traceKey{}, "span")
+		},
+		AfterExecute: func(ctx context.Context, job pool.Job, d time.Duration, r interface{}) {
+			if !strings.HasSuffix(job.Name(), "traceJob") && r == nil {
+				t.Errorf("unexpected job name: %s", job.Name())
+			}
+			lock.Lock()
+			recovered = append(recovered, r)
+			lock.Unlock()
+			record("after")
+		},
+		OnWorkerStart: func(w *pool.Worker) { record("start") },
+		OnWorkerExit: func(w *pool.Worker) {
+			record("exit")
+			close(exited)
+		},
+	})
+
+	job, _ := pool.NewJob(traceJob)
+	h, _ := p.Submit(job)
+	if err := h.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	bad, _ := pool.NewJob(func() { panic("bad job") })
+	h, _ = p.Submit(bad)
+	h.Wait()
+
+	// 空闲过期之后Worker被清理
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("worker exit hook is not called")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	expect := []string{"start", "before", "after", "before", "after", "exit"}
+	if strings.Join(events, ",") != strings.Join(expect, ",") {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	if recovered[0] != nil || recovered[1] != "bad job" {
+		t.Fatalf("unexpected recovered values: %v", recovered)
+	}
+}
+
+func TestStatefulPool(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		inits  int
+		closes int
+		states = make(map[interface{}]bool)
+	)
+	sp, err := pool.NewStatefulPool(2, 20*time.Millisecond, func() (interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		inits++
+		return &bytes.Buffer{}, nil
+	}, func(state interface{}) {
+		lock.Lock()
+		closes++
+		lock.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		sp.Submit(func(state interface{}) {
+			defer wg.Done()
+			buf := state.(*bytes.Buffer)
+			buf.Reset()
+			buf.WriteString("state")
+			lock.Lock()
+			states[state] = true
+			lock.Unlock()
+		})
+	}
+	wg.Wait()
+
+	// 空闲过期之后Worker被清理, 状态被释放
+	deadline := time.Now().Add(time.Second)
+	for {
+		lock.Lock()
+		n, c := inits, closes
+		lock.Unlock()
+		if n == c {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("states are not released, init: %d, close: %d", n, c)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	sp.Close()
+
+	if len(states) > 2 {
+		t.Fatalf("states are not reused: %d", len(states))
+	}
+}
+
+func TestStatefulPoolInitError(t *testing.T) {
+	errInit := errors.New("init error")
+	sp, _ := pool.NewStatefulPool(1, time.Second, func() (interface{}, error) {
+		return nil, errInit
+	}, nil)
+	defer sp.Close()
+
+	h, _ := sp.SubmitContext(context.Background(), func(ctx context.Context, state interface{}) {
+		t.Error("job should not be executed")
+	}, pool.PriorityNormal)
+	if err := h.Wait(); err != errInit {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func stealingFib(s *pool.Scope, n int, result *int) {
+	if n < 2 {
+		*result = n
+		return
+	}
+	var x, y int
+	s.Fork(func(s *pool.Scope) { stealingFib(s, n-1, &x) })
+	stealingFib(s, n-2, &y)
+	s.Join()
+	*result = x + y
+}
+
+func TestStealingPoolForkJoin(t *testing.T) {
+	sp, _ := pool.NewStealingPool(4)
+	defer sp.Close()
+
+	var result int
+	h, err := sp.Go(func(s *pool.Scope) {
+		stealingFib(s, 20, &result)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if result != 6765 {
+		t.Fatalf("unexpected result: %d", result)
+	}
+}
+
+// 子任务panic时, Join()把panic传递给父任务, 父任务不会使用缺失的结果继续计算
+func TestStealingPoolForkPanic(t *testing.T) {
+	sp, _ := pool.NewStealingPool(2)
+	defer sp.Close()
+
+	var completed, joined int32
+	h, _ := sp.Go(func(s *pool.Scope) {
+		s.Fork(func(*pool.Scope) {
+			panic("bad child")
+		})
+		s.Fork(func(*pool.Scope) { atomic.AddInt32(&completed, 1) })
+		s.Join()
+		atomic.AddInt32(&joined, 1)
+	})
+	if err := h.Wait(); err != pool.ErrJobPanicked {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&joined) != 0 {
+		t.Fatal("Join() should propagate the panic of a child")
+	}
+	if atomic.LoadInt32(&completed) != 1 {
+		t.Fatal("Join() should wait for all children before panicking")
+	}
+}
+
+func TestStealingPoolSubmit(t *testing.T) {
+	sp, _ := pool.NewStealingPool(2)
+	if sp.Running() != 2 || sp.Cap() != 2 {
+		t.Fatalf("unexpected running: %d, cap: %d", sp.Running(), sp.Cap())
+	}
+
+	var (
+		wg    sync.WaitGroup
+		count int32
+	)
+	job, _ := pool.NewJob(func() {
+		atomic.AddInt32(&count, 1)
+		wg.Done()
+	})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		sp.Submit(job)
+	}
+	wg.Wait()
+	if count != 100 {
+		t.Fatalf("unexpected count: %d", count)
+	}
+
+	h, _ := sp.Go(func(s *pool.Scope) { panic("boom") })
+	if err := h.Wait(); err != pool.ErrJobPanicked {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sp.Close()
+	if _, err := sp.Submit(job); err != pool.ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for sp.Running() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("workers are not exited: %d", sp.Running())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubmitBatch(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	release := blockPool(t, p)
+
+	var count int32
+	job, _ := pool.NewJob(func() { atomic.AddInt32(&count, 1) })
+	handles, err := p.SubmitBatch([]pool.Job{job, job, job})
+	if err != nil || len(handles) != 3 {
+		t.Fatalf("unexpected handles: %d, error: %v", len(handles), err)
+	}
+	close(release)
+	for _, h := range handles {
+		if err := h.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != 3 {
+		t.Fatalf("unexpected count: %d", count)
+	}
+
+	if _, err = p.SubmitBatch([]pool.Job{nil}); err != pool.ErrFunction {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Close()
+	if _, err = p.SubmitBatch([]pool.Job{job}); err != pool.ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	p, _ := pool.NewPool(4)
+	defer p.Close()
+
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	results, err := pool.Map(p, items, func(ctx context.Context, item int) (string, error) {
+		time.Sleep(time.Duration(item%3) * time.Millisecond)
+		return strings.Repeat("x", item), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if len(r) != i {
+			t.Fatalf("result %d out of order: %d", i, len(r))
+		}
+	}
+}
+
+func TestMapStopOnError(t *testing.T) {
+	p, _ := pool.NewPool(1)
+	defer p.Close()
+
+	errBad := errors.New("bad item")
+	var executed int32
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	_, err := pool.Map(p, items, func(ctx context.Context, item int) (int, error) {
+		atomic.AddInt32(&executed, 1)
+		if item == 2 {
+			return 0, errBad
+		}
+		return item, nil
+	})
+	if err != errBad {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if executed >= int32(len(items)) {
+		t.Fatalf("remaining items should be canceled, executed: %d", executed)
+	}
+
+	err = pool.ForEach(p, items, func(ctx context.Context, item int) error {
+		if item == 5 {
+			panic("bad item")
+		}
+		return nil
+	})
+	if err != pool.ErrJobPanicked {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMapAll(t *testing.T) {
+	p, _ := pool.NewPool(2)
+	defer p.Close()
+
+	errOdd := errors.New("odd item")
+	results, errs, err := pool.MapAll(p, []int{0, 1, 2, 3}, func(ctx context.Context, item int) (int, error) {
+		if item%2 == 1 {
+			return 0, errOdd
+		}
+		return item * 10, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range results {
+		if i%2 == 1 && errs[i] != errOdd || i%2 == 0 && (errs[i] != nil || results[i] != i*10) {
+			t.Fatalf("unexpected result %d: %d, %v", i, results[i], errs[i])
+		}
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	cases := []struct {
+		capacity int
+		options  []pool.Option
+		err      error
+	}{
+		{0, nil, pool.ErrInvalidPoolSize},
+		{-2, nil, pool.ErrInvalidPoolSize},
+		{10, []pool.Option{pool.WithExpiryDuration(-time.Second)}, pool.ErrInvalidPoolExpiry},
+		{10, []pool.Option{pool.WithMaxBlockingTasks(-1)}, pool.ErrInvalidBlockingTasks},
+		{10, []pool.Option{pool.WithNonblocking(true), pool.WithMaxBlockingTasks(1)}, pool.ErrInvalidBlockingTasks},
+		{-1, []pool.Option{pool.WithOptions(pool.Options{Nonblocking: true})}, nil},
+		{10, []pool.Option{pool.WithExpiryDuration(time.Second)}, nil},
+	}
+	for i, c := range cases {
+		p, err := pool.New(c.capacity, c.options...)
+		if err != c.err {
+			t.Fatalf("case %d, unexpected error: %v", i, err)
+		}
+		if p != nil {
+			p.Close()
+		}
+	}
+}
+
+// 阻塞唯一的Worker并填满任务队列
+func fillPool(t *testing.T, p *pool.Pool) chan struct{} {
+	release := blockPool(t, p)
+	job, _ := pool.NewJob(func() {})
+	for i := 0; i < pool.DefaultQueueSize; i++ {
+		if _, err := p.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return release
+}
+
+func TestNonblocking(t *testing.T) {
+	p, _ := pool.New(1, pool.WithNonblocking(true))
+	defer p.Close()
+	release := fillPool(t, p)
+	defer close(release)
+
+	job, _ := pool.NewJob(func() {})
+	if _, err := p.Submit(job); err != pool.ErrPoolOverload {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMaxBlockingTasks(t *testing.T) {
+	p, _ := pool.New(1, pool.WithMaxBlockingTasks(1))
+	defer p.Close()
+	release := fillPool(t, p)
+
+	job, _ := pool.NewJob(func() {})
+	blocked := make(chan error)
+	go func() {
+		_, err := p.Submit(job)
+		blocked <- err
+	}()
+
+	// 等待第一个提交阻塞, 第二个提交超过上限
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Waiters != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("submit should be blocked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := p.Submit(job); err != pool.ErrPoolOverload {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	close(release)
+	if err := <-blocked; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	recovered := make(chan interface{}, 1)
+	p, _ := pool.New(1, pool.WithPanicHandler(func(r interface{}) {
+		recovered <- r
+	}))
+	defer p.Close()
+
+	job, _ := pool.NewJob(func() { panic("boom") })
+	h, _ := p.Submit(job)
+	if err := h.Wait(); err != pool.ErrJobPanicked {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := <-recovered; r != "boom" {
+		t.Fatalf("unexpected recovered value: %v", r)
+	}
+}
+
+func TestUnlimitedPool(t *testing.T) {
+	p, _ := pool.New(-1)
+	defer p.Close()
+	if p.Cap() != -1 || p.Free() != -1 {
+		t.Fatalf("unexpected cap: %d, free: %d", p.Cap(), p.Free())
+	}
+
+	const workers = 50
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	release := make(chan struct{})
+	job, _ := pool.NewJob(func() {
+		wg.Done()
+		<-release
+	})
+	for i := 0; i < workers; i++ {
+		p.Submit(job)
+	}
+	wg.Wait() // 所有任务同时执行, 没有排队
+	if p.Running() != workers || p.Queued() != 0 {
+		t.Fatalf("unexpected running: %d, queued: %d", p.Running(), p.Queued())
+	}
+	close(release)
+
+	p.ResetCap(10)
+	if p.Cap() != 10 {
+		t.Fatalf("unexpected cap: %d", p.Cap())
+	}
+	if err := p.Autoscale(pool.AutoscaleConfig{MinCap: 1, MaxCap: 2, Interval: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	if err := pool.SetDefault(0); err != pool.ErrInvalidPoolSize {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	old := pool.Default()
+	if err := pool.SetDefault(8, pool.WithExpiryDuration(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if !old.IsClosed() || pool.Cap() != 8 {
+		t.Fatalf("default pool is not replaced, cap: %d", pool.Cap())
+	}
+	job, _ := pool.NewJob(func() {})
+	h, err := pool.Submit(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+}
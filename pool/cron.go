@@ -0,0 +1,170 @@
+package pool
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule 标准的5个字段的cron表达式: 分 时 日 月 周
+//
+//	字段    取值范围
+//	分      0-59
+//	时      0-23
+//	日      1-31
+//	月      1-12 或者 JAN-DEC
+//	周      0-7 或者 SUN-SAT (0和7都表示周日)
+//
+// 每个字段支持 "*", "a", "a-b", "*/n", "a-b/n", "a/n" 以及使用 "," 分隔的列表.
+// 月和周可以使用英文缩写代替数字, 不区分大小写, 例如 "MON-FRI".
+// 日和周都不以 "*" 开头时, 满足其中之一即可(与crontab的行为一致).
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // 每个字段允许的取值, 使用位图表示
+
+	domStar, dowStar bool // 日和周是否为 "*"
+}
+
+type cronBounds struct {
+	min, max int
+	names    []string // 从min开始的取值的英文缩写, nil表示只支持数字
+}
+
+var (
+	minuteBounds = cronBounds{0, 59, nil}
+	hourBounds   = cronBounds{0, 23, nil}
+	domBounds    = cronBounds{1, 31, nil}
+	monthBounds  = cronBounds{1, 12, []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	dowBounds    = cronBounds{0, 7, []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}}
+)
+
+// 解析一个取值, 数字或者英文缩写
+func (b cronBounds) value(s string) (int, error) {
+	for i, name := range b.names {
+		if strings.EqualFold(s, name) {
+			return b.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ErrInvalidCron
+	}
+	return n, nil
+}
+
+// 解析cron表达式
+func ParseCron(spec string) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrInvalidCron
+	}
+
+	var (
+		s   CronSchedule
+		err error
+	)
+	if s.minute, err = parseCronField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+	// 7 和 0 都表示周日
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// 解析一个字段, 返回允许取值的位图
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		// 步长
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidCron
+			}
+			step, stepped = n, true
+			part = part[:i]
+		}
+
+		// 范围
+		start, end := bounds.min, bounds.max
+		switch i := strings.Index(part, "-"); {
+		case part == "*":
+		case i >= 0:
+			var err1, err2 error
+			start, err1 = bounds.value(part[:i])
+			end, err2 = bounds.value(part[i+1:])
+			if err1 != nil || err2 != nil {
+				return 0, ErrInvalidCron
+			}
+		default:
+			n, err := bounds.value(part)
+			if err != nil {
+				return 0, err
+			}
+			start = n
+			if !stepped { // "a" 表示单个值, "a/n" 表示从a开始到上限
+				end = n
+			}
+		}
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, ErrInvalidCron
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// 返回t之后(不包括t)下一次触发的时间. 不存在时(例如2月30日)返回零值
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
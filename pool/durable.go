@@ -0,0 +1,440 @@
+package pool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Record 一条持久化的任务记录. 任务函数按照名字注册, 只有名字和参数被持久化
+type Record struct {
+	ID   uint64            `json:"id"`
+	Name string            `json:"name"`
+	Args []json.RawMessage `json:"args"` // 每个参数的JSON编码
+}
+
+// Queue 持久化的任务队列. 提交的任务先追加到队列, 任务结束之后确认;
+// 进程重启之后, 没有确认的任务被重放
+type Queue interface {
+	// 追加一条任务记录, 并为记录分配ID
+	Append(rec *Record) error
+	// 确认任务已经结束, 不再重放
+	Ack(id uint64) error
+	// 所有没有确认的任务记录, 按照追加的顺序排列
+	Pending() ([]*Record, error)
+	Close() error
+}
+
+//-------------------------------------------------------------------------
+
+// MemoryQueue 内存实现的Queue, 进程退出之后丢失, 用于测试或者不需要持久化的场景
+type MemoryQueue struct {
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]*Record
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{pending: make(map[uint64]*Record)}
+}
+
+func (q *MemoryQueue) Append(rec *Record) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.nextID++
+	rec.ID = q.nextID
+	q.pending[rec.ID] = rec
+	return nil
+}
+
+func (q *MemoryQueue) Ack(id uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.pending, id)
+	return nil
+}
+
+func (q *MemoryQueue) Pending() ([]*Record, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return sortRecords(q.pending), nil
+}
+
+func (q *MemoryQueue) Close() error {
+	return nil
+}
+
+//-------------------------------------------------------------------------
+
+const (
+	walAppend = "append"
+	walAck    = "ack"
+
+	// 确认的记录数超过该值, 且超过未确认的记录数时, 压缩日志文件
+	walCompactThreshold = 1024
+)
+
+// walEntry 预写日志(WAL)当中的一行
+type walEntry struct {
+	Op     string  `json:"op"`
+	Record *Record `json:"record,omitempty"`
+	ID     uint64  `json:"id,omitempty"`
+}
+
+// FileQueue 基于文件预写日志(WAL)的Queue. 每次追加和确认都写入一行JSON并同步到磁盘,
+// 打开时重放日志恢复未确认的任务.
+type FileQueue struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	nextID  uint64
+	pending map[uint64]*Record
+	acked   int // 上一次压缩之后确认的记录数
+}
+
+// 打开或者创建日志文件
+func OpenFileQueue(path string) (*FileQueue, error) {
+	q := &FileQueue{
+		path:    path,
+		pending: make(map[uint64]*Record),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	q.file = file
+	return q, nil
+}
+
+// 重放日志. 进程崩溃时最后一行可能不完整: 无法解析时截断; 可以解析但是缺少换行符时补上换行符,
+// 否则之后追加的记录会写在同一行
+func (q *FileQueue) load() error {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		corrupted bool
+		valid     int64 // 完整的日志的长度, 每一行都按照有换行符计算
+	)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if corrupted { // 不完整的行之后还有数据, 日志已经损坏
+			return ErrCorruptedQueue
+		}
+
+		var entry walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			corrupted = true
+			continue
+		}
+		valid += int64(len(scanner.Bytes())) + 1
+		switch entry.Op {
+		case walAppend:
+			if entry.Record == nil {
+				return ErrCorruptedQueue
+			}
+			q.pending[entry.Record.ID] = entry.Record
+			if entry.Record.ID > q.nextID {
+				q.nextID = entry.Record.ID
+			}
+		case walAck:
+			delete(q.pending, entry.ID)
+		default:
+			return ErrCorruptedQueue
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if corrupted {
+		return os.Truncate(q.path, valid)
+	}
+	if valid > info.Size() {
+		return q.repair()
+	}
+	return nil
+}
+
+// 为缺少换行符的最后一行补上换行符
+func (q *FileQueue) repair() error {
+	file, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write([]byte{'\n'}); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (q *FileQueue) Append(rec *Record) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.nextID++
+	rec.ID = q.nextID
+	if err := q.write(walEntry{Op: walAppend, Record: rec}); err != nil {
+		return err
+	}
+	q.pending[rec.ID] = rec
+	return nil
+}
+
+func (q *FileQueue) Ack(id uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.pending[id]; !ok {
+		return nil
+	}
+	if err := q.write(walEntry{Op: walAck, ID: id}); err != nil {
+		return err
+	}
+	delete(q.pending, id)
+
+	q.acked++
+	if q.acked >= walCompactThreshold && q.acked > len(q.pending) {
+		return q.compact()
+	}
+	return nil
+}
+
+func (q *FileQueue) Pending() ([]*Record, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return sortRecords(q.pending), nil
+}
+
+func (q *FileQueue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.file.Close()
+}
+
+// 写入一行日志并同步到磁盘. 调用方需要持有锁
+func (q *FileQueue) write(entry walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = q.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return q.file.Sync()
+}
+
+// 压缩日志: 只保留未确认的记录, 写入临时文件之后原子替换. 调用方需要持有锁
+func (q *FileQueue) compact() error {
+	tmp := q.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, rec := range sortRecords(q.pending) {
+		data, err := json.Marshal(walEntry{Op: walAppend, Record: rec})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err = writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+
+	q.file.Close()
+	if q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	q.acked = 0
+	return nil
+}
+
+func sortRecords(pending map[uint64]*Record) []*Record {
+	records := make([]*Record, 0, len(pending))
+	for _, rec := range pending {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+//-------------------------------------------------------------------------
+
+// DurablePool 任务持久化的Pool. 任务函数按照名字注册, 参数使用JSON编码持久化到Queue,
+// 任务结束(完成, 取消, 超时或者panic)之后确认. 进程重启之后调用Replay()重新提交未结束的任务.
+type DurablePool struct {
+	pool  *Pool
+	queue Queue
+
+	lock  sync.RWMutex
+	funcs map[string]interface{} // 注册的任务函数
+
+	flight   sync.Mutex          // 保证追加记录与标记在执行当中是原子的, Replay()不会看到没有标记的新记录
+	inflight map[uint64]struct{} // 本进程提交之后还没有确认的任务
+}
+
+func NewDurablePool(pool *Pool, queue Queue) *DurablePool {
+	return &DurablePool{
+		pool:     pool,
+		queue:    queue,
+		funcs:    make(map[string]interface{}),
+		inflight: make(map[uint64]struct{}),
+	}
+}
+
+// 按照名字注册任务函数
+func (d *DurablePool) Register(name string, function interface{}) error {
+	if typ := reflect.TypeOf(function); typ == nil || typ.Kind() != reflect.Func {
+		return ErrFunction
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.funcs[name] = function
+	return nil
+}
+
+// 提交已经注册的任务. args必须可以使用JSON编码
+func (d *DurablePool) Submit(name string, args ...interface{}) (*Handle, error) {
+	rec := &Record{Name: name, Args: make([]json.RawMessage, len(args))}
+	for i, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		rec.Args[i] = data
+	}
+
+	// 先校验任务可以创建, 再持久化
+	job, err := d.job(rec)
+	if err != nil {
+		return nil, err
+	}
+	d.flight.Lock()
+	if err = d.queue.Append(rec); err != nil {
+		d.flight.Unlock()
+		return nil, err
+	}
+	d.inflight[rec.ID] = struct{}{}
+	d.flight.Unlock()
+
+	h, err := d.submit(rec.ID, job)
+	if err != nil {
+		d.queue.Ack(rec.ID) // 调用方已经收到错误, 重启之后不能再执行该任务
+		d.done(rec.ID)
+		return nil, err
+	}
+	return h, nil
+}
+
+// 重新提交所有未结束的任务, 返回提交的数量. 本进程提交的还在排队或者执行的任务不会被重复提交
+func (d *DurablePool) Replay() (int, error) {
+	d.flight.Lock()
+	records, err := d.queue.Pending()
+	if err != nil {
+		d.flight.Unlock()
+		return 0, err
+	}
+	replay := records[:0]
+	for _, rec := range records {
+		if _, ok := d.inflight[rec.ID]; !ok {
+			d.inflight[rec.ID] = struct{}{}
+			replay = append(replay, rec)
+		}
+	}
+	d.flight.Unlock()
+
+	for i, rec := range replay {
+		job, err := d.job(rec)
+		if err == nil {
+			_, err = d.submit(rec.ID, job)
+		}
+		if err != nil {
+			for _, rec := range replay[i:] { // 没有提交的任务可以被再次重放
+				d.done(rec.ID)
+			}
+			return i, err
+		}
+	}
+	return len(replay), nil
+}
+
+// 提交任务, 任务结束之后确认. 确认在Handle.Done()关闭之前完成
+func (d *DurablePool) submit(id uint64, job *job) (*Handle, error) {
+	return d.pool.submit(context.Background(), job, PriorityNormal, func(error) {
+		d.queue.Ack(id) // 确认失败时, 任务在重启之后被再次执行
+		d.done(id)
+	})
+}
+
+// 任务结束, 先确认再取消标记, 避免并发的Replay()重放已经执行过的任务
+func (d *DurablePool) done(id uint64) {
+	d.flight.Lock()
+	delete(d.inflight, id)
+	d.flight.Unlock()
+}
+
+// 根据任务记录创建任务, 参数按照函数的参数类型解码
+func (d *DurablePool) job(rec *Record) (*job, error) {
+	d.lock.RLock()
+	function, ok := d.funcs[rec.Name]
+	d.lock.RUnlock()
+	if !ok {
+		return nil, ErrJobNotRegistered
+	}
+
+	typ := reflect.TypeOf(function)
+	offset := typ.NumIn() - len(rec.Args) // 第一个参数为context.Context时为1
+	if offset != 0 && (offset != 1 || typ.In(0) != contextType) {
+		return nil, ErrFunctionArgs
+	}
+
+	args := make([]interface{}, len(rec.Args))
+	for i, data := range rec.Args {
+		arg := reflect.New(typ.In(i + offset))
+		if err := json.Unmarshal(data, arg.Interface()); err != nil {
+			return nil, err
+		}
+		args[i] = arg.Elem().Interface()
+	}
+	return NewJob(function, args...)
+}
@@ -0,0 +1,84 @@
+package pool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+const (
+	handlePending  = iota // 任务在排队, 还没有开始执行
+	handleRunning         // 任务正在执行
+	handleFinished        // 任务已经结束(完成, 取消, 超时或者panic)
+)
+
+// Handle 一次提交的任务的句柄, 可以取消任务或者等待任务结束.
+// 没有开始执行的任务取消之后不再执行; 正在执行的任务通过context得到取消的通知.
+type Handle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	state    int32
+	done     chan struct{}
+	err      error
+	onFinish func(err error) // 任务结束时, 在关闭done之前回调
+}
+
+func newHandle(parent context.Context, onFinish func(err error)) *Handle {
+	ctx, cancel := context.WithCancel(parent)
+	return &Handle{
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		onFinish: onFinish,
+	}
+}
+
+// 取消任务. 任务还没有开始执行时立即结束, Err()返回context.Canceled
+func (h *Handle) Cancel() {
+	h.cancel()
+	h.finish(handlePending, context.Canceled)
+}
+
+// 任务结束之后关闭的管道
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// 任务结束的原因: nil表示正常完成, context.Canceled表示被取消,
+// context.DeadlineExceeded表示执行超时, ErrJobPanicked表示发生panic. 任务没有结束时返回nil
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// 等待任务结束, 返回值与Err()相同
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// 开始执行任务. 任务已经被取消时结束任务并返回false, Worker跳过该任务
+func (h *Handle) start() bool {
+	if err := h.ctx.Err(); err != nil {
+		h.finish(handlePending, err)
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&h.state, handlePending, handleRunning)
+}
+
+// 从状态from结束任务, 状态不匹配时忽略
+func (h *Handle) finish(from int32, err error) {
+	if !atomic.CompareAndSwapInt32(&h.state, from, handleFinished) {
+		return
+	}
+	h.err = err
+	h.cancel()
+	if h.onFinish != nil {
+		h.onFinish(err)
+	}
+	close(h.done)
+}
@@ -0,0 +1,49 @@
+package pool
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// Job 回调当中的任务
+type Job interface {
+	// 任务函数的名字, 例如 "main.handle", 用于日志和链路追踪
+	Name() string
+}
+
+func (f *job) Name() string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f.function).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
+
+// Hooks Pool的生命周期回调, 为nil的回调不执行. 回调在Worker的协程当中同步执行
+type Hooks struct {
+	// 任务执行之前回调. 返回的context作为任务的上下文, 可以携带日志字段或者链路追踪的span; 返回nil时使用原来的context
+	BeforeExecute func(ctx context.Context, job Job) context.Context
+
+	// 任务执行之后回调. duration是任务的执行时长, recovered是任务panic时恢复的值, 没有panic时为nil
+	AfterExecute func(ctx context.Context, job Job, duration time.Duration, recovered interface{})
+
+	// Worker的协程开启之后回调
+	OnWorkerStart func(w *Worker)
+
+	// Worker的协程退出之前回调(空闲过期被清理, 缩容或者Pool关闭)
+	OnWorkerExit func(w *Worker)
+}
+
+// 设置生命周期回调, 对之后开启的Worker和执行的任务生效
+func (p *Pool) SetHooks(hooks Hooks) {
+	p.hooks.Store(&hooks)
+}
+
+func (p *Pool) loadHooks() *Hooks {
+	hooks, _ := p.hooks.Load().(*Hooks)
+	if hooks == nil {
+		return &Hooks{}
+	}
+	return hooks
+}
@@ -0,0 +1,194 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiters的数量达到该值时开始清理空闲的限流器
+const minSweepSize = 64
+
+// KeyedPool 在Pool之上按照key串行执行任务: 相同key的任务按照提交顺序依次执行,
+// 不同key的任务在Pool的Worker上并发执行. 例如按照用户处理事件.
+type KeyedPool struct {
+	pool *Pool
+
+	lock   sync.Mutex
+	queues map[interface{}]*keyQueue // 正在执行的key对应的任务队列, 队列执行完成之后立即删除
+
+	keyLimit  *keyRateLimit            // 每个key的限流配置, nil表示不限流
+	limiters  map[interface{}]*Limiter // 每个key的限流器
+	sweepSize int                      // limiters的数量超过该值时, 清理空闲的限流器
+}
+
+// keyRateLimit 每个key的限流配置
+type keyRateLimit struct {
+	rate     float64
+	burst    int
+	maxDelay time.Duration
+	clock    Clock
+}
+
+// keyQueue 某个key排队的任务, 同一时刻只有一个Worker在执行
+type keyQueue struct {
+	jobs  []*job
+	ready chan struct{} // 执行队列的runner被Pool接受或者拒绝之后关闭
+	err   error         // Pool拒绝runner的原因, 排队的任务全部以该错误返回给调用方
+}
+
+func NewKeyedPool(pool *Pool) *KeyedPool {
+	return &KeyedPool{
+		pool:   pool,
+		queues: make(map[interface{}]*keyQueue),
+	}
+}
+
+// 为每个key设置独立的限流, 令牌不足时Submit()最多等待maxDelay. clock为nil时使用系统时钟
+func (kp *KeyedPool) SetKeyRateLimit(rate float64, burst int, maxDelay time.Duration, clock Clock) error {
+	if rate <= 0 || burst <= 0 {
+		return ErrInvalidRateLimit
+	}
+
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	kp.keyLimit = &keyRateLimit{rate: rate, burst: burst, maxDelay: maxDelay, clock: clock}
+	kp.limiters = make(map[interface{}]*Limiter)
+	kp.sweepSize = minSweepSize
+	return nil
+}
+
+// 提交任务. key必须是可比较的类型. 每个任务都需要通过key的限流和Pool的限流.
+// 该key的runner还在提交时等待提交的结果, runner被Pool拒绝时, 队列当中的任务全部以该错误返回
+func (kp *KeyedPool) Submit(key interface{}, task *job) error {
+	if err := kp.acquire(key); err != nil {
+		return err
+	}
+	if err := kp.pool.acquire(); err != nil {
+		return err
+	}
+
+	kp.lock.Lock()
+	if q, ok := kp.queues[key]; ok { // 该key已经有Worker在执行, 排队即可
+		q.jobs = append(q.jobs, task)
+		kp.lock.Unlock()
+		<-q.ready
+		return q.err
+	}
+	q := &keyQueue{jobs: []*job{task}, ready: make(chan struct{})}
+	kp.queues[key] = q
+	kp.lock.Unlock()
+
+	runner := &job{
+		function:    func(ctx context.Context, state interface{}) { kp.drain(ctx, state, key, q) },
+		withContext: true,
+		withState:   true,
+		internal:    true,
+	}
+	if _, err := kp.pool.Submit(runner); err != nil {
+		kp.lock.Lock()
+		delete(kp.queues, key)
+		q.jobs = nil
+		q.err = err
+		kp.lock.Unlock()
+		close(q.ready)
+		return err
+	}
+	close(q.ready)
+	return nil
+}
+
+// 当前有任务在执行或者排队的key的数量
+func (kp *KeyedPool) Keys() int {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	return len(kp.queues)
+}
+
+// 获取key的限流器, 需要等待时阻塞. 超出限制返回ErrRateLimited
+func (kp *KeyedPool) acquire(key interface{}) error {
+	kp.lock.Lock()
+	limit := kp.keyLimit
+	if limit == nil {
+		kp.lock.Unlock()
+		return nil
+	}
+	limiter, ok := kp.limiters[key]
+	if !ok {
+		if len(kp.limiters) >= kp.sweepSize {
+			kp.sweepLimiters()
+		}
+		limiter, _ = NewLimiter(limit.rate, limit.burst, limit.clock)
+		kp.limiters[key] = limiter
+	}
+	kp.lock.Unlock()
+
+	if !limiter.Wait(limit.maxDelay) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// 清理令牌桶已满的限流器, 这些key在一段时间内没有提交任务. 调用方需要持有锁
+func (kp *KeyedPool) sweepLimiters() {
+	for key, limiter := range kp.limiters {
+		if limiter.full() {
+			delete(kp.limiters, key)
+		}
+	}
+	// 清理之后仍然很多, 下一次清理的阈值翻倍, 保证均摊的开销
+	if n := 2 * len(kp.limiters); n > minSweepSize {
+		kp.sweepSize = n
+	} else {
+		kp.sweepSize = minSweepSize
+	}
+}
+
+// 依次执行某个key的任务, 队列为空时删除该key, 回收空闲的队列
+func (kp *KeyedPool) drain(ctx context.Context, state interface{}, key interface{}, q *keyQueue) {
+	for {
+		kp.lock.Lock()
+		if len(q.jobs) == 0 {
+			delete(kp.queues, key)
+			kp.lock.Unlock()
+			return
+		}
+		f := q.jobs[0]
+		q.jobs[0] = nil
+		q.jobs = q.jobs[1:]
+		kp.lock.Unlock()
+
+		kp.execute(ctx, state, f)
+	}
+}
+
+// 执行任务, 与Worker执行任务一样设置超时, 传入Worker的状态, 回调BeforeExecute/AfterExecute,
+// panic交给Pool的PanicHandler. 任务发生panic时恢复, 保证该key后续的任务可以继续执行
+func (kp *KeyedPool) execute(ctx context.Context, state interface{}, f *job) {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	p := kp.pool
+	hooks := p.loadHooks()
+	start := time.Now()
+	defer func() {
+		r := recover()
+		if hooks.AfterExecute != nil {
+			hooks.AfterExecute(ctx, f, time.Since(start), r)
+		}
+		if r != nil {
+			p.metrics.panic()
+			p.handlePanic(r)
+		}
+	}()
+
+	if hooks.BeforeExecute != nil {
+		if c := hooks.BeforeExecute(ctx, f); c != nil {
+			ctx = c
+		}
+	}
+	f.execute(ctx, state)
+}
@@ -0,0 +1,131 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟接口, 测试时可以替换为可控的时钟
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Limiter 令牌桶限流器. 令牌以rate(个/秒)的速度生成, 桶中最多存放burst个令牌.
+// 令牌不足时可以预支未来的令牌, 调用方需要等待到令牌生成的时刻.
+type Limiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64   // 当前的令牌数, 预支之后可以为负数
+	last   time.Time // 上一次更新令牌数的时间
+	clock  Clock
+}
+
+// 创建限流器, 初始时桶是满的. clock为nil时使用系统时钟
+func NewLimiter(rate float64, burst int, clock Clock) (*Limiter, error) {
+	if rate <= 0 || burst <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   clock.Now(),
+		clock:  clock,
+	}, nil
+}
+
+// 获取一个令牌, 不等待. 没有令牌返回false
+func (l *Limiter) Allow() bool {
+	_, ok := l.reserve(0)
+	return ok
+}
+
+// 获取一个令牌, 令牌不足时最多等待maxDelay. 需要等待的时间超过maxDelay时不消耗令牌, 返回false
+func (l *Limiter) Wait(maxDelay time.Duration) bool {
+	delay, ok := l.reserve(maxDelay)
+	if !ok {
+		return false
+	}
+	if delay > 0 {
+		<-l.clock.After(delay)
+	}
+	return true
+}
+
+// 预留一个令牌, 返回需要等待的时长
+func (l *Limiter) reserve(maxDelay time.Duration) (time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	l.advance(now)
+
+	tokens := l.tokens - 1
+	var delay time.Duration
+	if tokens < 0 {
+		delay = time.Duration(-tokens / l.rate * float64(time.Second))
+	}
+	if delay > maxDelay {
+		return 0, false
+	}
+	l.tokens = tokens
+	return delay, true
+}
+
+// 桶是否已满, 即一段时间内没有使用
+func (l *Limiter) full() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.advance(l.clock.Now())
+	return l.tokens >= float64(l.burst)
+}
+
+// 根据流逝的时间生成令牌. 调用方需要持有锁
+func (l *Limiter) advance(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+		l.last = now
+	}
+}
+
+// rateLimit Pool的限流配置
+type rateLimit struct {
+	limiter  *Limiter
+	maxDelay time.Duration // 令牌不足时Submit()最多等待的时长, 超过之后返回ErrRateLimited
+}
+
+// 设置Pool的限流器. limiter为nil时取消限流
+func (p *Pool) SetRateLimit(limiter *Limiter, maxDelay time.Duration) {
+	p.limit.Store(&rateLimit{limiter: limiter, maxDelay: maxDelay})
+}
+
+// 限流, 需要等待时阻塞. 超出限制返回ErrRateLimited
+func (p *Pool) acquire() error {
+	limit, _ := p.limit.Load().(*rateLimit)
+	if limit == nil || limit.limiter == nil {
+		return nil
+	}
+	if !limit.limiter.Wait(limit.maxDelay) {
+		return ErrRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package pool
+
+import (
+	"math"
+	"runtime/debug"
+	"time"
+)
+
+// 容量为-1时Pool不限制Worker的数量, 内部使用int32的最大值表示
+const unlimitedCap = math.MaxInt32
+
+// Logger 记录Pool内部事件(例如任务panic)的日志接口, *log.Logger 满足该接口
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Options 创建Pool的配置
+type Options struct {
+	// 空闲Worker的过期时长, 也是清理的周期. 0表示使用DefaultCleanInterval
+	ExpiryDuration time.Duration
+
+	// 非阻塞模式: 任务队列已满时Submit()不等待, 立即返回ErrPoolOverload
+	Nonblocking bool
+
+	// 任务队列已满时, 阻塞在Submit()当中的协程数量的上限, 超过之后返回ErrPoolOverload. 0表示不限制
+	MaxBlockingTasks int
+
+	// 任务panic时回调, 参数是恢复的值. 为nil时使用Logger记录
+	PanicHandler func(recovered interface{})
+
+	// 日志, 为nil时不记录
+	Logger Logger
+}
+
+// Option 修改Options的函数
+type Option func(opts *Options)
+
+// 使用完整的配置
+func WithOptions(options Options) Option {
+	return func(opts *Options) {
+		*opts = options
+	}
+}
+
+func WithExpiryDuration(expiry time.Duration) Option {
+	return func(opts *Options) {
+		opts.ExpiryDuration = expiry
+	}
+}
+
+func WithNonblocking(nonblocking bool) Option {
+	return func(opts *Options) {
+		opts.Nonblocking = nonblocking
+	}
+}
+
+func WithMaxBlockingTasks(n int) Option {
+	return func(opts *Options) {
+		opts.MaxBlockingTasks = n
+	}
+}
+
+func WithPanicHandler(handler func(recovered interface{})) Option {
+	return func(opts *Options) {
+		opts.PanicHandler = handler
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
+// 校验配置, 并填充默认值
+func (opts *Options) validate(capacity int) error {
+	if capacity == 0 || capacity < -1 {
+		return ErrInvalidPoolSize
+	}
+	if opts.ExpiryDuration < 0 {
+		return ErrInvalidPoolExpiry
+	}
+	if opts.ExpiryDuration == 0 {
+		opts.ExpiryDuration = DefaultCleanInterval
+	}
+	if opts.MaxBlockingTasks < 0 || (opts.Nonblocking && opts.MaxBlockingTasks > 0) {
+		return ErrInvalidBlockingTasks
+	}
+	return nil
+}
+
+// 任务panic时回调PanicHandler, 没有设置时记录日志
+func (p *Pool) handlePanic(r interface{}) {
+	if p.options.PanicHandler != nil {
+		p.options.PanicHandler(r)
+		return
+	}
+	if p.options.Logger != nil {
+		p.options.Logger.Printf("job panicked: %v\n%s", r, debug.Stack())
+	}
+}
@@ -1,139 +1,365 @@
 package pool
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type sig struct{}
+const (
+	OPENED = iota // Pool处于开启状态
+	CLOSED        // Pool处于关闭状态
+)
 
 type Pool struct {
-	capacity int32 // Pool的容量, 即开启worker数量的上限, 每一个worker绑定一个goroutine.
+	capacity int32 // Pool的容量, 即开启worker数量的上限, 每一个worker绑定一个goroutine. 不限容量时为unlimitedCap
 	running  int32 // 当前正在执行任务的worker数量
 
-	expiryDuration time.Duration // 每个协程的运行时长(second)
+	expiryDuration time.Duration // 空闲worker的过期时长, 也是清理的周期
+
+	options *Options // 创建Pool的配置
+
+	idleWorkers *workerStack // 存放空闲worker
+
+	queue *taskQueue // 没有可用的worker时, 任务在队列当中排队
+
+	state int32 // Pool的状态, OPENED 或 CLOSED
 
-	idleWorkers []*Worker // 存放空闲worker
+	stopPurge context.CancelFunc // 停止定期清理的协程
 
-	signal chan sig // 关闭协程池的信号
+	autoscale     *AutoscaleConfig   // 自动伸缩的配置, nil表示不开启
+	stopAutoscale context.CancelFunc // 停止自动伸缩的协程
+
+	limit atomic.Value // 限流配置 *rateLimit
+	hooks atomic.Value // 生命周期回调 *Hooks
+
+	stateInit  InitFunc  // 创建Worker的状态, 用于StatefulPool
+	stateClose CloseFunc // 释放Worker的状态
+
+	metrics *metrics // 统计计数
+	waiters int      // 因为队列已满, 阻塞在Submit()当中的协程数量
 
 	lock sync.Mutex // 锁,用以支持Pool的同步操作
 	cond *sync.Cond // 唤醒操作
-
-	once sync.Once
 }
 
 // time.NewTicker() 定时器, 每间隔 d 向Ticker当中的管道C发送当时的时间
 // time.NewTimer()  定时器, 经过时间 d 之后, 向Timer当中的管道C发送当前时间
-func (p *Pool) periodicallyPurge() {
-	// 心跳检测空闲worker
+func (p *Pool) periodicallyPurge(ctx context.Context) {
+	// 心跳检测空闲worker, 退出时停止Ticker
 	heartbeat := time.NewTicker(p.expiryDuration)
-	for range heartbeat.C { // 周期性阻塞,
-		currentTime := time.Now()
-		p.lock.Lock()
-		idleWorkers := p.idleWorkers
-		// 空闲的Worker为0, 运行的Worker为0, 且发送关闭信号
-		if len(idleWorkers) == 0 && p.Running() == 0 && len(p.signal) > 0 {
-			p.lock.Unlock()
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done(): // Close() 或 Shutdown() 停止清理
 			return
+		case <-heartbeat.C: // 周期性阻塞
 		}
-		n := -1
-		for i, w := range idleWorkers {
-			if currentTime.Sub(w.recycleTime) <= p.expiryDuration {
-				break
-			}
-			n = i
-			w.job <- nil
-			idleWorkers[i] = nil
-		}
-		if n > -1 {
-			if n >= len(idleWorkers)-1 {
-				p.idleWorkers = idleWorkers[:0]
-			} else {
-				p.idleWorkers = idleWorkers[n+1:]
-			}
-		}
-		p.lock.Unlock()
+
+		p.purgeExpiredWorkers(time.Now())
 	}
 }
 
+// 清理过期的空闲worker
+func (p *Pool) purgeExpiredWorkers(currentTime time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	expiry := p.idleWorkers.retrieveExpiry(currentTime, p.expiryDuration)
+	for i, w := range expiry {
+		p.stopWorker(w)
+		expiry[i] = nil
+	}
+	atomic.AddInt64(&p.metrics.purged, int64(len(expiry)))
+}
+
 func NewPool(capacity int) (*Pool, error) {
 	return NewTimingPool(capacity, DefaultCleanInterval)
 }
 
 // 自定义协程池
-func NewTimingPool(capacity, expiry int) (*Pool, error) {
+func NewTimingPool(capacity int, expiry time.Duration) (*Pool, error) {
 	if capacity <= 0 {
 		return nil, ErrInvalidPoolSize
 	}
 	if expiry <= 0 {
 		return nil, ErrInvalidPoolExpiry
 	}
+	return New(capacity, WithExpiryDuration(expiry))
+}
+
+// 使用配置创建协程池. capacity为-1时不限制Worker的数量
+func New(capacity int, options ...Option) (*Pool, error) {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if err := opts.validate(capacity); err != nil {
+		return nil, err
+	}
+
 	p := &Pool{
 		capacity:       int32(capacity),
-		signal:         make(chan sig, 1),
-		expiryDuration: time.Duration(expiry) * time.Second,
+		expiryDuration: opts.ExpiryDuration,
+		options:        opts,
+		idleWorkers:    newWorkerStack(),
+		queue:          newTaskQueue(DefaultQueueSize),
+		metrics:        new(metrics),
+	}
+	if capacity == -1 {
+		p.capacity = unlimitedCap
 	}
 	p.cond = sync.NewCond(&p.lock)
-	go p.periodicallyPurge()
+	p.startPurge()
 	return p, nil
 }
 
+// 开启定期清理的协程
+func (p *Pool) startPurge() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopPurge = cancel
+	go p.periodicallyPurge(ctx)
+}
+
 //-------------------------------------------------------------------------
 
-// 提交任务
-func (p *Pool) Submit(job *job) error {
-	if len(p.signal) > 0 {
-		return ErrPoolClosed
+// 提交任务, 使用默认的优先级
+func (p *Pool) Submit(job *job) (*Handle, error) {
+	return p.SubmitContext(context.Background(), job, PriorityNormal)
+}
+
+// 按照优先级提交任务
+func (p *Pool) SubmitWithPriority(job *job, priority Priority) (*Handle, error) {
+	return p.SubmitContext(context.Background(), job, priority)
+}
+
+// 提交任务. 有可用的Worker时直接执行, 否则在任务队列当中排队, 队列已满时阻塞.
+// ctx取消之后, 阻塞的提交立即返回, 排队的任务不再执行, 正在执行的任务通过ctx得到通知.
+func (p *Pool) SubmitContext(ctx context.Context, job *job, priority Priority) (*Handle, error) {
+	return p.submit(ctx, job, priority, nil)
+}
+
+// 提交任务, onFinish在任务结束时回调
+func (p *Pool) submit(ctx context.Context, job *job, priority Priority, onFinish func(error)) (*Handle, error) {
+	if priority < PriorityLow || priority > PriorityHigh {
+		return nil, ErrInvalidPriority
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !job.internal { // 内部任务在提交实际的任务时已经限流
+		if err := p.acquire(); err != nil {
+			return nil, err
+		}
+	}
+	submitAt := time.Now()
 
-	p.getWorker().job <- job
+	// 快速路径: 没有排队的任务时, 不加锁从空闲栈取出Worker
+	if p.queue.len() == 0 && !p.IsClosed() {
+		if w := p.idleWorkers.detach(); w != nil {
+			atomic.AddInt64(&p.metrics.submitted, 1)
+			t := &task{job: job, handle: newHandle(ctx, onFinish), submitAt: submitAt}
+			p.recordWait(time.Since(submitAt))
+			w.task <- t
+			return t.handle, nil
+		}
+	}
 
-	return nil
+	// 在锁内检查Pool的状态, 避免与Close()竞争
+	p.lock.Lock()
+	if !p.IsClosed() && p.queue.full() {
+		if p.options.Nonblocking || (p.options.MaxBlockingTasks > 0 && p.waiters >= p.options.MaxBlockingTasks) {
+			p.lock.Unlock()
+			return nil, ErrPoolOverload
+		}
+		stop := p.wakeOnDone(ctx)
+		for !p.IsClosed() && p.queue.full() && ctx.Err() == nil {
+			p.waiters++
+			p.cond.Wait()
+			p.waiters--
+		}
+		close(stop)
+	}
+	if p.IsClosed() {
+		p.lock.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if err := ctx.Err(); err != nil {
+		p.lock.Unlock()
+		return nil, err
+	}
+	atomic.AddInt64(&p.metrics.submitted, 1)
+
+	t := &task{job: job, handle: newHandle(ctx, onFinish), submitAt: submitAt}
+
+	// 已经有任务在排队, 或者没有可用的Worker, 进入队列保证先来先服务.
+	// 入队之后检查空闲栈, 与不加锁放回的Worker竞争时, 由其中一方把任务交给空闲的Worker
+	var w *Worker
+	if p.queue.len() == 0 {
+		w = p.idleWorkers.detach()
+	}
+	if w == nil && (p.queue.len() > 0 || p.Running() >= p.maxWorkers()) {
+		p.queue.push(t, priority)
+		p.rebalance()
+		p.lock.Unlock()
+		return t.handle, nil
+	}
+
+	if w == nil {
+		w = p.retrieveWorker()
+	}
+	p.lock.Unlock()
+	p.recordWait(time.Since(submitAt))
+	w.task <- t
+
+	return t.handle, nil
+}
+
+// ctx取消时唤醒阻塞在Submit()当中的协程, 关闭返回的管道之后停止监听
+func (p *Pool) wakeOnDone(ctx context.Context) chan struct{} {
+	stop := make(chan struct{})
+	if ctx.Done() == nil {
+		return stop
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.lock.Lock()
+			p.cond.Broadcast()
+			p.lock.Unlock()
+		case <-stop:
+		}
+	}()
+	return stop
 }
 
 func (p *Pool) Running() int {
 	return int(atomic.LoadInt32(&p.running))
 }
 
+// 空闲的Worker数量
 func (p *Pool) Idle() int {
-	return int(atomic.LoadInt32(&p.capacity) - atomic.LoadInt32(&p.running))
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.idleWorkers.len()
 }
 
+// 还可以创建的Worker数量, 即 capacity - running. 不限容量时返回-1
+func (p *Pool) Free() int {
+	if p.Cap() == -1 {
+		return -1
+	}
+	return p.maxWorkers() - p.Running()
+}
+
+// Pool的容量, 不限容量时返回-1
 func (p *Pool) Cap() int {
+	if c := p.maxWorkers(); c != unlimitedCap {
+		return c
+	}
+	return -1
+}
+
+// 开启Worker数量的上限
+func (p *Pool) maxWorkers() int {
 	return int(atomic.LoadInt32(&p.capacity))
 }
 
-// 重置Pool的容量
+// 某个优先级当中排队的任务数量
+func (p *Pool) QueueLen(priority Priority) int {
+	if priority < PriorityLow || priority > PriorityHigh {
+		return 0
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.queue.levelLen(priority)
+}
+
+// 排队的任务总数
+func (p *Pool) Queued() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.queue.len()
+}
+
+// 重置Pool的容量, 不会阻塞调用方. capacity为-1时不限制Worker的数量.
+// 扩容时为排队的任务创建新的Worker; 缩容时空闲的Worker立即退出, 正在执行任务的Worker在任务完成之后退出
 func (p *Pool) ResetCap(capacity int) {
-	if capacity == p.Cap() {
+	if capacity == 0 || capacity < -1 || capacity == p.Cap() {
 		return
 	}
+	if capacity == -1 {
+		capacity = unlimitedCap
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	atomic.StoreInt32(&p.capacity, int32(capacity))
-	diff := p.Running() - capacity
-	for i := 0; i < diff; i++ {
-		p.getWorker().job <- nil
+
+	for p.queue.len() > 0 && p.Running() < p.maxWorkers() {
+		t := p.queue.pop()
+		p.notifyWaiters()
+		p.recordWait(time.Since(t.submitAt))
+		p.retrieveWorker().task <- t
 	}
+	p.rebalance()
+}
+
+func (p *Pool) IsClosed() bool {
+	return atomic.LoadInt32(&p.state) == CLOSED
 }
 
-// 关闭
+// 关闭, 不再接收新的任务. 空闲的Worker立即退出, 正在执行的Worker执行完队列当中的任务之后退出
 func (p *Pool) Close() error {
-	p.once.Do(func() {
-		p.signal <- sig{}
-		p.lock.Lock()
-		defer p.lock.Unlock()
-
-		idleWorkers := p.idleWorkers
-		for i, w := range idleWorkers {
-			w.job <- nil
-			idleWorkers[i] = nil
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&p.state, OPENED, CLOSED) {
+		return nil
+	}
+
+	p.rebalance()
+	p.stopPurge()
+	if p.stopAutoscale != nil {
+		p.stopAutoscale()
+	}
+	p.cond.Broadcast() // 唤醒阻塞在Submit()当中的协程
+	return nil
+}
+
+// 优雅关闭, 不再接收新的任务, 等待正在执行和排队的任务完成, 直到ctx结束.
+// 定期清理的协程在Close()当中停止
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for p.Running() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
-		p.idleWorkers = nil
-	})
+	}
 	return nil
 }
 
+// 重新开启已经关闭的Pool
+func (p *Pool) Reboot() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if atomic.CompareAndSwapInt32(&p.state, CLOSED, OPENED) {
+		p.startPurge()
+		if p.autoscale != nil {
+			p.startAutoscale()
+		}
+	}
+}
+
 //-------------------------------------------------------------------------
 
 // 增加运行的Worker数量
@@ -146,55 +372,102 @@ func (p *Pool) decRunning() {
 	atomic.AddInt32(&p.running, -1)
 }
 
-// 获取一个Worker, 调度算法的核心
-func (p *Pool) getWorker() *Worker {
-	var w *Worker
-	waiting := false
+// 记录一次任务的等待时长
+func (p *Pool) recordWait(d time.Duration) {
+	p.metrics.wait(d)
+}
 
-	p.lock.Lock()
-	defer p.lock.Unlock()
+// 唤醒因为队列已满阻塞在Submit()当中的协程. 调用方需要持有锁, 没有等待者时不调用Broadcast()
+func (p *Pool) notifyWaiters() {
+	if p.waiters > 0 {
+		p.cond.Broadcast()
+	}
+}
 
-	idleWorkers := p.idleWorkers
-	n := len(idleWorkers) - 1
-	if n < 0 {
-		// 没有空闲的Wotker, 检查是否需要等待
-		waiting = p.Running() >= p.Cap()
-	} else {
-		// 有空闲的Worker, 取出空闲的Worker
-		w = idleWorkers[n]
-		idleWorkers[n] = nil
-		p.idleWorkers = idleWorkers[:n]
-	}
-
-	if waiting {
-		for {
-			p.cond.Wait() // 等待
-			l := len(p.idleWorkers) - 1
-			if l < 0 {
-				continue
-			}
-			w = p.idleWorkers[l]
-			p.idleWorkers[l] = nil
-			p.idleWorkers = p.idleWorkers[:l]
-			break
-		}
-	} else if w == nil { // 创建一个新的Worker
-		w = &Worker{
-			pool: p,
-			job:  make(chan *job, 1),
-		}
-		w.run()
-		p.incRunning()
+// 通知空闲的Worker退出. 调用方需要持有锁, running在此处减少, 保证容量判断的一致性
+func (p *Pool) stopWorker(w *Worker) {
+	w.task <- nil
+	p.decRunning()
+}
+
+// 取出一个空闲的Worker, 没有空闲的Worker则创建一个新的Worker. 调用方需要持有锁
+func (p *Pool) retrieveWorker() *Worker {
+	if w := p.idleWorkers.detach(); w != nil {
+		return w
 	}
 
+	w := &Worker{
+		pool: p,
+		task: make(chan *task, 1),
+	}
+	w.run()
+	p.incRunning()
 	return w
 }
 
-// 回收Worker
-func (p *Pool) putWorker(worker *Worker) {
-	worker.recycleTime = time.Now() // 设置Worker的回收时间
+// 回收Worker. 如果队列当中有排队的任务, 则返回下一个任务交给该Worker继续执行.
+// Worker超出容量(缩容), 或者Pool已经关闭且没有排队的任务时返回false, Worker需要退出
+func (p *Pool) putWorker(worker *Worker) (*task, bool) {
+	// 快速路径: 没有排队的任务时, 不加锁放回空闲栈. 入栈之后再次检查,
+	// 期间有任务入队, Pool被关闭或者缩容时, 由加锁的rebalance()处理
+	if p.queue.len() == 0 && !p.IsClosed() && p.Running() <= p.maxWorkers() {
+		worker.recycleTime = time.Now()
+		p.idleWorkers.insert(worker)
+		if p.queue.len() > 0 || p.IsClosed() || p.Running() > p.maxWorkers() {
+			p.lock.Lock()
+			p.rebalance()
+			p.lock.Unlock()
+		}
+		return nil, true
+	}
+
 	p.lock.Lock()
-	p.idleWorkers = append(p.idleWorkers, worker)
-	p.cond.Signal() // 通知有一个空闲的worker
-	p.lock.Unlock()
+	defer p.lock.Unlock()
+
+	if p.Running() > p.maxWorkers() {
+		p.decRunning()
+		return nil, false
+	}
+	if t := p.queue.pop(); t != nil {
+		p.notifyWaiters() // 通知队列有空闲的位置
+		p.recordWait(time.Since(t.submitAt))
+		return t, true
+	}
+	if p.IsClosed() {
+		p.decRunning()
+		return nil, false
+	}
+
+	worker.recycleTime = time.Now() // 设置Worker的回收时间
+	p.idleWorkers.insert(worker)
+	return nil, true
+}
+
+// 使空闲栈与队列, 状态和容量保持一致: 排队的任务交给空闲的Worker; Pool已经关闭时空闲的Worker退出;
+// 超出容量时空闲的Worker退出. 在任务入队, Worker不加锁入栈, Close()和ResetCap()之后调用. 调用方需要持有锁
+func (p *Pool) rebalance() {
+	for p.queue.len() > 0 {
+		w := p.idleWorkers.detach()
+		if w == nil {
+			break
+		}
+		t := p.queue.pop()
+		p.notifyWaiters()
+		p.recordWait(time.Since(t.submitAt))
+		w.task <- t
+	}
+
+	if p.IsClosed() {
+		for _, w := range p.idleWorkers.reset() {
+			p.stopWorker(w)
+		}
+		return
+	}
+	for p.Running() > p.maxWorkers() {
+		w := p.idleWorkers.detach()
+		if w == nil {
+			break
+		}
+		p.stopWorker(w)
+	}
 }
@@ -0,0 +1,101 @@
+package pool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Priority 任务的优先级, 数值越大优先级越高
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	priorityLevels = 3
+)
+
+// 低优先级的任务连续被跳过的次数上限, 超过之后优先调度一次, 防止饥饿
+const starvationLimit = 8
+
+// task 一次提交的任务, 在队列当中排队或者交给Worker执行
+type task struct {
+	job      *job
+	handle   *Handle   // 任务的句柄, 用于取消任务和通知任务结束
+	submitAt time.Time // 提交的时间, 用于统计任务的等待时长
+}
+
+// taskQueue 有界的多级任务队列. 不同优先级之间高优先级先出队, 同一优先级内先进先出(FIFO).
+// taskQueue 本身不是并发安全的, 由Pool的锁保护; 只有len()可以在不持有锁时调用.
+type taskQueue struct {
+	levels   [priorityLevels][]*task // 每个优先级一个FIFO队列
+	skipped  [priorityLevels]int     // 每个优先级被跳过的次数
+	size     int32                   // 队列当中任务的总数, 原子读写
+	capacity int                     // 队列的容量
+}
+
+func newTaskQueue(capacity int) *taskQueue {
+	return &taskQueue{capacity: capacity}
+}
+
+func (q *taskQueue) len() int {
+	return int(atomic.LoadInt32(&q.size))
+}
+
+func (q *taskQueue) levelLen(priority Priority) int {
+	return len(q.levels[priority])
+}
+
+func (q *taskQueue) full() bool {
+	return q.len() >= q.capacity
+}
+
+// 入队, 队列已满返回false
+func (q *taskQueue) push(t *task, priority Priority) bool {
+	if q.full() {
+		return false
+	}
+	q.levels[priority] = append(q.levels[priority], t)
+	atomic.AddInt32(&q.size, 1)
+	return true
+}
+
+// 出队, 队列为空返回nil
+func (q *taskQueue) pop() *task {
+	if q.len() == 0 {
+		return nil
+	}
+
+	// 先检查是否存在饥饿的队列, 再按照优先级从高到低选择
+	level := -1
+	for i := priorityLevels - 1; i >= 0; i-- {
+		if len(q.levels[i]) > 0 && q.skipped[i] >= starvationLimit {
+			level = i
+			break
+		}
+	}
+	if level < 0 {
+		for i := priorityLevels - 1; i >= 0; i-- {
+			if len(q.levels[i]) > 0 {
+				level = i
+				break
+			}
+		}
+	}
+
+	// 比选中的优先级低且有任务在等待的队列, 记录一次跳过
+	for i := 0; i < level; i++ {
+		if len(q.levels[i]) > 0 {
+			q.skipped[i]++
+		}
+	}
+	q.skipped[level] = 0
+
+	tasks := q.levels[level]
+	t := tasks[0]
+	tasks[0] = nil
+	q.levels[level] = tasks[1:]
+	atomic.AddInt32(&q.size, -1)
+	return t
+}
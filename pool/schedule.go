@@ -0,0 +1,166 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// Timer 延迟执行或者周期执行的任务的句柄. 任务到期之后提交到Pool, 由Pool的Worker执行, 受Pool容量的限制.
+// 提交的结果通过Handle()和Err()获取, 不再提交任务之后Done()被关闭
+type Timer struct {
+	lock       sync.Mutex
+	timer      *time.Timer
+	canceled   bool
+	submitting bool          // 正在提交任务(可能阻塞在已满的队列), 周期任务在此期间到期的调度被跳过
+	finished   bool          // 不再提交任务
+	handle     *Handle       // 最近一次提交成功的任务的句柄
+	err        error         // 最近一次提交的错误
+	done       chan struct{} // finished之后关闭
+}
+
+func newTimer() *Timer {
+	return &Timer{done: make(chan struct{})}
+}
+
+// 取消任务, 之后不再提交. 返回false表示任务已经或者正在提交, 可以通过Handle()取消正在执行的任务;
+// 对于周期执行的任务, 返回false时仍然会停止后续的调度
+func (t *Timer) Cancel() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.canceled || t.finished {
+		return false
+	}
+	t.canceled = true
+	t.timer.Stop()
+	if t.submitting { // 提交返回之后结束
+		return false
+	}
+	t.finish(nil)
+	return true
+}
+
+// 最近一次提交成功的任务的句柄, 还没有提交时返回nil
+func (t *Timer) Handle() *Handle {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.handle
+}
+
+// 最近一次提交的错误: Pool返回的错误(ErrPoolClosed, ErrPoolOverload, ErrRateLimited等),
+// 或者周期任务因为上一次的提交仍然阻塞而跳过时返回ErrScheduleOverrun
+func (t *Timer) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.err
+}
+
+// 不再提交任务之后关闭: 延迟执行的任务提交之后, 取消之后, Pool关闭之后, 或者周期任务没有下一次触发的时间
+func (t *Timer) Done() <-chan struct{} {
+	return t.done
+}
+
+// 结束定时器. 调用方需要持有t.lock
+func (t *Timer) finish(err error) {
+	if t.finished {
+		return
+	}
+	t.finished = true
+	if err != nil {
+		t.err = err
+	}
+	close(t.done)
+}
+
+// 到期时提交任务. reschedule不为nil时是周期任务, 在提交之前设置下一次的定时器, 返回false表示没有下一次
+func (t *Timer) fire(p *Pool, job *job, reschedule func() bool) {
+	t.lock.Lock()
+	if t.canceled || t.finished {
+		t.lock.Unlock()
+		return
+	}
+	if p.IsClosed() {
+		t.finish(ErrPoolClosed)
+		t.lock.Unlock()
+		return
+	}
+	last := reschedule == nil || !reschedule()
+	if t.submitting { // 上一次的提交还在阻塞, 跳过本次调度, 同一时刻最多只有一个提交
+		t.err = ErrScheduleOverrun
+		if last {
+			t.canceled = true // 没有下一次调度, 正在进行的提交返回之后结束
+		}
+		t.lock.Unlock()
+		return
+	}
+	t.submitting = true
+	t.lock.Unlock()
+
+	h, err := p.Submit(job)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.submitting = false
+	t.err = err
+	if err == nil {
+		t.handle = h
+	}
+	if last || t.canceled || err == ErrPoolClosed {
+		t.finish(nil)
+	}
+}
+
+// 经过d之后提交任务
+func (p *Pool) SubmitAfter(d time.Duration, job *job) (*Timer, error) {
+	if p.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	t := newTimer()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.timer = time.AfterFunc(d, func() {
+		t.fire(p, job, nil)
+	})
+	return t, nil
+}
+
+// 在时刻at提交任务, at已经过去时立即提交
+func (p *Pool) SubmitAt(at time.Time, job *job) (*Timer, error) {
+	return p.SubmitAfter(time.Until(at), job)
+}
+
+// 按照cron表达式周期提交任务, Pool关闭之后停止调度
+func (p *Pool) Schedule(spec string, job *job) (*Timer, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	if p.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	t := newTimer()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !p.scheduleNext(t, schedule, job, time.Now()) {
+		return nil, ErrInvalidCron
+	}
+	return t, nil
+}
+
+// 计算下一次触发的时间并设置定时器, 没有下一次触发的时间返回false. 调用方需要持有t.lock
+func (p *Pool) scheduleNext(t *Timer, schedule *CronSchedule, job *job, now time.Time) bool {
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return false
+	}
+
+	t.timer = time.AfterFunc(next.Sub(now), func() {
+		// 先设置下一次的定时器, 再提交任务, 避免提交阻塞影响调度的时间
+		t.fire(p, job, func() bool {
+			return p.scheduleNext(t, schedule, job, next)
+		})
+	})
+	return true
+}
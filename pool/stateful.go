@@ -0,0 +1,68 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+// InitFunc 创建Worker的状态, 例如解析器或者缓冲区
+type InitFunc func() (interface{}, error)
+
+// CloseFunc 释放Worker的状态
+type CloseFunc func(state interface{})
+
+// StatefulPool 每个Worker绑定一个状态的Pool. Worker开启时调用InitFunc创建状态,
+// 被清理(空闲过期, 缩容或者Pool关闭)时调用CloseFunc释放状态. 任务执行时传入所在Worker的状态,
+// 同一个状态同一时刻只会被一个任务使用, 不需要借助sync.Pool.
+type StatefulPool struct {
+	*Pool
+}
+
+func NewStatefulPool(capacity int, expiry time.Duration, init InitFunc, close CloseFunc) (*StatefulPool, error) {
+	if init == nil {
+		return nil, ErrFunction
+	}
+	p, err := NewTimingPool(capacity, expiry)
+	if err != nil {
+		return nil, err
+	}
+	p.stateInit = init
+	p.stateClose = close
+	return &StatefulPool{Pool: p}, nil
+}
+
+// 提交任务, fn在Worker上执行, 传入该Worker的状态
+func (sp *StatefulPool) Submit(fn func(state interface{})) (*Handle, error) {
+	return sp.Pool.Submit(&job{function: fn, withState: true})
+}
+
+// 提交任务, fn在Worker上执行, 传入任务的上下文和该Worker的状态
+func (sp *StatefulPool) SubmitContext(ctx context.Context, fn func(ctx context.Context, state interface{}), priority Priority) (*Handle, error) {
+	return sp.Pool.SubmitContext(ctx, &job{function: fn, withContext: true, withState: true}, priority)
+}
+
+// 创建Worker的状态. 创建失败时返回错误, 下一次执行任务时重试
+func (w *Worker) initState() error {
+	if w.pool.stateInit == nil || w.stateReady {
+		return nil
+	}
+	state, err := w.pool.stateInit()
+	if err != nil {
+		return err
+	}
+	w.state = state
+	w.stateReady = true
+	return nil
+}
+
+// 释放Worker的状态
+func (w *Worker) closeState() {
+	if !w.stateReady {
+		return
+	}
+	if w.pool.stateClose != nil {
+		w.pool.stateClose(w.state)
+	}
+	w.state = nil
+	w.stateReady = false
+}
@@ -0,0 +1,121 @@
+package pool
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// metrics Pool的统计计数, 全部使用原子操作. 单独分配内存, 保证在32位机器上的64位对齐
+type metrics struct {
+	submitted int64 // 提交的任务数
+	completed int64 // 正常完成的任务数
+	panicked  int64 // 发生panic的任务数
+	purged    int64 // 因为空闲过期被清理的Worker数
+
+	waitTotal int64 // 任务等待时长的累计(纳秒)
+	waitCount int64 // 任务等待次数的累计
+	execTotal int64 // 任务执行时长的累计(纳秒)
+
+	windowWaitTotal int64 // 自动伸缩检查周期内的等待时长, 每个周期读取并清零
+	windowWaitCount int64 // 自动伸缩检查周期内的等待次数
+}
+
+func (m *metrics) wait(d time.Duration) {
+	atomic.AddInt64(&m.waitTotal, int64(d))
+	atomic.AddInt64(&m.waitCount, 1)
+	atomic.AddInt64(&m.windowWaitTotal, int64(d))
+	atomic.AddInt64(&m.windowWaitCount, 1)
+}
+
+func (m *metrics) complete(d time.Duration) {
+	atomic.AddInt64(&m.execTotal, int64(d))
+	atomic.AddInt64(&m.completed, 1)
+}
+
+func (m *metrics) panic() {
+	atomic.AddInt64(&m.panicked, 1)
+}
+
+// Stats Pool某一时刻的统计快照
+type Stats struct {
+	Capacity int // 容量
+	Running  int // 存活的Worker数量
+	Idle     int // 空闲的Worker数量
+	Queued   int // 排队的任务数
+	Waiters  int // 因为队列已满, 阻塞在Submit()当中的协程数量
+
+	Submitted uint64 // 提交的任务数
+	Completed uint64 // 正常完成的任务数
+	Panicked  uint64 // 发生panic的任务数
+	Purged    uint64 // 因为空闲过期被清理的Worker数
+
+	AvgWait time.Duration // 任务从提交到开始执行的平均时长
+	AvgExec time.Duration // 任务的平均执行时长(不包括发生panic的任务)
+
+	WaitTotal time.Duration // 任务等待时长的累计, 与WaitCount一起可以计算任意时间段的平均值
+	WaitCount uint64        // 任务等待次数的累计
+	ExecTotal time.Duration // 任务执行时长的累计, 次数为Completed
+}
+
+// 获取统计快照
+func (p *Pool) Stats() Stats {
+	p.lock.Lock()
+	s := Stats{
+		Capacity: p.Cap(),
+		Running:  p.Running(),
+		Idle:     p.idleWorkers.len(),
+		Queued:   p.queue.len(),
+		Waiters:  p.waiters,
+	}
+	p.lock.Unlock()
+
+	m := p.metrics
+	s.Submitted = uint64(atomic.LoadInt64(&m.submitted))
+	s.Completed = uint64(atomic.LoadInt64(&m.completed))
+	s.Panicked = uint64(atomic.LoadInt64(&m.panicked))
+	s.Purged = uint64(atomic.LoadInt64(&m.purged))
+	s.WaitTotal = time.Duration(atomic.LoadInt64(&m.waitTotal))
+	s.WaitCount = uint64(atomic.LoadInt64(&m.waitCount))
+	s.ExecTotal = time.Duration(atomic.LoadInt64(&m.execTotal))
+	if s.WaitCount > 0 {
+		s.AvgWait = s.WaitTotal / time.Duration(s.WaitCount)
+	}
+	if s.Completed > 0 {
+		s.AvgExec = s.ExecTotal / time.Duration(s.Completed)
+	}
+	return s
+}
+
+// 以Prometheus文本格式导出统计数据的http.Handler, name作为pool标签的值用于区分不同的Pool
+func (p *Pool) MetricsHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := p.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		metrics := []struct {
+			name, typ, help string
+			value           float64
+		}{
+			{"pool_capacity", "gauge", "Capacity of the pool.", float64(s.Capacity)},
+			{"pool_running_workers", "gauge", "Number of alive workers.", float64(s.Running)},
+			{"pool_idle_workers", "gauge", "Number of idle workers.", float64(s.Idle)},
+			{"pool_queued_tasks", "gauge", "Number of tasks waiting in the queue.", float64(s.Queued)},
+			{"pool_waiters", "gauge", "Number of submitters blocked on a full queue.", float64(s.Waiters)},
+			{"pool_submitted_total", "counter", "Total number of submitted tasks.", float64(s.Submitted)},
+			{"pool_completed_total", "counter", "Total number of completed tasks.", float64(s.Completed)},
+			{"pool_panicked_total", "counter", "Total number of panicked tasks.", float64(s.Panicked)},
+			{"pool_purged_workers_total", "counter", "Total number of expired workers purged.", float64(s.Purged)},
+			{"pool_wait_seconds_sum", "counter", "Total time from submit to execution.", s.WaitTotal.Seconds()},
+			{"pool_wait_seconds_count", "counter", "Total number of tasks started after waiting.", float64(s.WaitCount)},
+			{"pool_exec_seconds_sum", "counter", "Total execution time of completed tasks.", s.ExecTotal.Seconds()},
+			{"pool_exec_seconds_count", "counter", "Total number of completed tasks with measured execution time.", float64(s.Completed)},
+		}
+		for _, m := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+			fmt.Fprintf(w, "%s{pool=%q} %g\n", m.name, name, m.value)
+		}
+	})
+}
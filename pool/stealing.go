@@ -0,0 +1,274 @@
+package pool
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// StealingPool 工作窃取(work-stealing)的Pool, 适用于CPU密集型的任务.
+// 每个Worker拥有一个双端队列: Worker从队尾取出自己派生的任务(LIFO, 缓存更热),
+// 空闲的Worker从其他Worker的队头窃取任务(FIFO, 窃取到的任务粒度更大).
+// 外部提交的任务进入全局队列. 任务可以通过Scope派生子任务并等待完成(fork/join).
+type StealingPool struct {
+	workers []*stealWorker
+	global  deque // 外部提交的任务
+
+	pending int64 // 所有队列当中任务的总数
+	idle    int32 // 休眠的Worker数量
+	running int32 // 存活的Worker数量
+	state   int32
+
+	lock sync.Mutex
+	cond *sync.Cond // 没有任务时Worker休眠
+}
+
+// stealTask 工作窃取队列当中的任务
+type stealTask struct {
+	job    *job
+	fn     func(s *Scope) // fork/join的任务, 与job二选一
+	handle *Handle
+	parent *Scope // 派生该任务的Scope, 任务结束之后通知
+}
+
+type stealWorker struct {
+	pool  *StealingPool
+	local deque
+}
+
+// deque 双端队列. 所有者从队尾存取, 窃取者从队头取出
+type deque struct {
+	lock  sync.Mutex
+	items []*stealTask
+}
+
+func (d *deque) pushBottom(t *stealTask) {
+	d.lock.Lock()
+	d.items = append(d.items, t)
+	d.lock.Unlock()
+}
+
+func (d *deque) popBottom() *stealTask {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	n := len(d.items) - 1
+	if n < 0 {
+		return nil
+	}
+	t := d.items[n]
+	d.items[n] = nil
+	d.items = d.items[:n]
+	return t
+}
+
+func (d *deque) popTop() *stealTask {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.items) == 0 {
+		return nil
+	}
+	t := d.items[0]
+	d.items[0] = nil
+	d.items = d.items[1:]
+	return t
+}
+
+// Scope fork/join的作用域. 任务通过Fork()派生子任务, 通过Join()等待所有子任务完成
+type Scope struct {
+	worker  *stealWorker
+	pending int32 // 没有完成的子任务数量
+
+	lock      sync.Mutex
+	recovered interface{} // 第一个panic的子任务恢复的值
+}
+
+// 派生子任务, 子任务放入当前Worker的队列, 可能被其他Worker窃取
+func (s *Scope) Fork(fn func(s *Scope)) {
+	atomic.AddInt32(&s.pending, 1)
+	s.worker.pool.push(&s.worker.local, &stealTask{fn: fn, parent: s})
+}
+
+// 等待所有派生的子任务完成. 等待期间当前Worker继续执行其他任务, 避免所有Worker都在等待.
+// 有子任务panic时, 所有子任务完成之后使用第一个panic的值重新panic, 传递给父任务
+func (s *Scope) Join() {
+	for atomic.LoadInt32(&s.pending) > 0 {
+		if t := s.worker.find(); t != nil {
+			s.worker.execute(t)
+			continue
+		}
+		runtime.Gosched()
+	}
+
+	s.lock.Lock()
+	r := s.recovered
+	s.recovered = nil
+	s.lock.Unlock()
+	if r != nil {
+		panic(r)
+	}
+}
+
+// 记录子任务的panic, 只保留第一个
+func (s *Scope) panicked(r interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.recovered == nil {
+		s.recovered = r
+	}
+}
+
+// 创建工作窃取的Pool, 开启size个Worker. size <= 0 时使用 runtime.GOMAXPROCS(0)
+func NewStealingPool(size int) (*StealingPool, error) {
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+	p := &StealingPool{
+		workers: make([]*stealWorker, size),
+		running: int32(size),
+	}
+	p.cond = sync.NewCond(&p.lock)
+	for i := range p.workers {
+		p.workers[i] = &stealWorker{pool: p}
+	}
+	for _, w := range p.workers {
+		go w.run()
+	}
+	return p, nil
+}
+
+// 提交任务
+func (p *StealingPool) Submit(job *job) (*Handle, error) {
+	return p.submit(&stealTask{job: job})
+}
+
+// 提交fork/join的任务, fn可以通过Scope派生子任务
+func (p *StealingPool) Go(fn func(s *Scope)) (*Handle, error) {
+	return p.submit(&stealTask{fn: fn})
+}
+
+func (p *StealingPool) submit(t *stealTask) (*Handle, error) {
+	if p.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+	t.handle = newHandle(context.Background(), nil)
+	p.push(&p.global, t)
+	return t.handle, nil
+}
+
+// 存活的Worker数量
+func (p *StealingPool) Running() int {
+	return int(atomic.LoadInt32(&p.running))
+}
+
+func (p *StealingPool) Cap() int {
+	return len(p.workers)
+}
+
+func (p *StealingPool) IsClosed() bool {
+	return atomic.LoadInt32(&p.state) == CLOSED
+}
+
+// 关闭, 不再接收新的任务. Worker执行完所有队列当中的任务之后退出
+func (p *StealingPool) Close() error {
+	if atomic.CompareAndSwapInt32(&p.state, OPENED, CLOSED) {
+		p.lock.Lock()
+		p.cond.Broadcast()
+		p.lock.Unlock()
+	}
+	return nil
+}
+
+// 任务放入队列, 有休眠的Worker时唤醒一个
+func (p *StealingPool) push(d *deque, t *stealTask) {
+	d.pushBottom(t)
+	atomic.AddInt64(&p.pending, 1)
+	if atomic.LoadInt32(&p.idle) > 0 {
+		p.lock.Lock()
+		p.cond.Signal()
+		p.lock.Unlock()
+	}
+}
+
+func (w *stealWorker) run() {
+	p := w.pool
+	defer atomic.AddInt32(&p.running, -1)
+
+	for {
+		if t := w.find(); t != nil {
+			w.execute(t)
+			continue
+		}
+
+		// 先增加休眠数量再检查任务数, 与push()的顺序相反, 保证不会丢失唤醒
+		p.lock.Lock()
+		atomic.AddInt32(&p.idle, 1)
+		for atomic.LoadInt64(&p.pending) <= 0 && !p.IsClosed() {
+			p.cond.Wait()
+		}
+		atomic.AddInt32(&p.idle, -1)
+		exit := atomic.LoadInt64(&p.pending) <= 0 && p.IsClosed()
+		p.lock.Unlock()
+
+		if exit {
+			return
+		}
+	}
+}
+
+// 查找任务: 自己的队尾, 全局队列, 最后从随机的Worker开始窃取
+func (w *stealWorker) find() *stealTask {
+	p := w.pool
+	t := w.local.popBottom()
+	if t == nil {
+		t = p.global.popTop()
+	}
+	if t == nil {
+		n := len(p.workers)
+		start := rand.Intn(n)
+		for i := 0; i < n && t == nil; i++ {
+			if victim := p.workers[(start+i)%n]; victim != w {
+				t = victim.local.popTop()
+			}
+		}
+	}
+	if t != nil {
+		atomic.AddInt64(&p.pending, -1)
+	}
+	return t
+}
+
+func (w *stealWorker) execute(t *stealTask) {
+	if t.parent != nil {
+		defer atomic.AddInt32(&t.parent.pending, -1)
+	}
+	if t.handle != nil && !t.handle.start() {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if t.parent != nil {
+				t.parent.panicked(r) // 在减少父任务的pending之前记录, Join()返回之前可以看到
+			}
+			if t.handle != nil {
+				t.handle.finish(handleRunning, ErrJobPanicked)
+			}
+			return
+		}
+		if t.handle != nil {
+			t.handle.finish(handleRunning, nil)
+		}
+	}()
+
+	if t.fn != nil {
+		t.fn(&Scope{worker: w})
+		return
+	}
+	ctx := context.Background()
+	if t.handle != nil {
+		ctx = t.handle.ctx
+	}
+	t.job.execute(ctx, nil)
+}
@@ -1,15 +1,25 @@
 package pool
 
 import (
+	"context"
 	"reflect"
 	"time"
 )
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 type job struct {
 	function interface{}
 	args     []interface{}
+
+	withContext bool          // 函数的第一个参数是context.Context, 执行时传入任务的上下文
+	withState   bool          // 函数接收Worker的状态(在context.Context之后), 用于StatefulPool
+	timeout     time.Duration // 任务的执行超时时间, 0表示没有超时
+	internal    bool          // 内部任务(KeyedPool的runner), 不回调BeforeExecute/AfterExecute, 由内部逐个回调实际的任务
 }
 
+// 创建任务. 函数的第一个参数是context.Context且args不包含该参数时, 执行时传入任务的上下文,
+// 任务被取消或者超时之后, 上下文随之结束
 func NewJob(function interface{}, args ...interface{}) (*job, error) {
 	var (
 		val = reflect.ValueOf(function)
@@ -24,22 +34,45 @@ func NewJob(function interface{}, args ...interface{}) (*job, error) {
 		return nil, ErrFunction
 	}
 
-	if typ.NumIn() != len(args) {
+	withContext := typ.NumIn() == len(args)+1 && typ.In(0) == contextType
+	if !withContext && typ.NumIn() != len(args) {
 		return nil, ErrFunctionArgs
 	}
 
 	return &job{
-		function: val.Interface(),
-		args:     args,
+		function:    val.Interface(),
+		args:        args,
+		withContext: withContext,
 	}, nil
 }
 
+// 返回设置了执行超时时间的任务副本, 超时从任务开始执行时计算
+func (f *job) WithTimeout(timeout time.Duration) *job {
+	c := *f
+	c.timeout = timeout
+	return &c
+}
+
 func (f *job) Execute() {
+	f.execute(context.Background(), nil)
+}
+
+func (f *job) execute(ctx context.Context, state interface{}) {
 	fun := reflect.ValueOf(f.function)
-	args := make([]reflect.Value, len(f.args))
+	args := make([]reflect.Value, 0, len(f.args)+2)
 
-	for k, v := range f.args {
-		args[k] = reflect.ValueOf(v)
+	if f.withContext {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	if f.withState {
+		args = append(args, reflect.ValueOf(&state).Elem())
+	}
+	for _, v := range f.args {
+		if v == nil { // nil参数使用对应参数类型的零值
+			args = append(args, reflect.Zero(fun.Type().In(len(args))))
+			continue
+		}
+		args = append(args, reflect.ValueOf(v))
 	}
 
 	fun.Call(args)
@@ -48,21 +81,89 @@ func (f *job) Execute() {
 type Worker struct {
 	pool *Pool
 
-	job chan *job
+	task chan *task
 
 	recycleTime time.Time // 在将空闲的worker放回到空闲列表当中, recycleTime更新为当前的时间
+
+	state      interface{} // Worker的状态, 由StatefulPool的InitFunc创建
+	stateReady bool        // 状态是否已经创建
 }
 
 func (w *Worker) run() {
 	go func() {
-		for f := range w.job {
-			if f == nil { // 协程退出, 超过空闲事件会执行
-				w.pool.decRunning()
+		w.initState()
+		if hook := w.pool.loadHooks().OnWorkerStart; hook != nil {
+			hook(w)
+		}
+		defer func() {
+			if hook := w.pool.loadHooks().OnWorkerExit; hook != nil {
+				hook(w)
+			}
+			w.closeState()
+		}()
+
+		for t := range w.task {
+			if t == nil { // 协程退出, 超过空闲时间, 缩容或者关闭时会执行
 				return
 			}
 
-			f.Execute()
-			w.pool.putWorker(w)
+			// 执行完成之后, 继续执行队列当中排队的任务
+			for t != nil {
+				w.execute(t)
+
+				var ok bool
+				if t, ok = w.pool.putWorker(w); !ok { // 缩容或者Pool已经关闭, 协程退出
+					return
+				}
+			}
+		}
+	}()
+}
+
+// 执行任务, 统计执行时长. 已经取消的任务直接跳过; 任务发生panic时恢复, Worker继续工作
+func (w *Worker) execute(t *task) {
+	h := t.handle
+	if !h.start() {
+		return
+	}
+	if err := w.initState(); err != nil { // Worker的状态创建失败, 任务以该错误结束
+		h.finish(handleRunning, err)
+		return
+	}
+
+	ctx := h.ctx
+	if t.job.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.job.timeout)
+		defer cancel()
+	}
+
+	hooks := w.pool.loadHooks()
+	if t.job.internal {
+		hooks = &Hooks{}
+	}
+	start := time.Now()
+	defer func() {
+		r := recover()
+		duration := time.Since(start)
+		if hooks.AfterExecute != nil {
+			hooks.AfterExecute(ctx, t.job, duration, r)
+		}
+
+		if r != nil {
+			w.pool.metrics.panic()
+			w.pool.handlePanic(r)
+			h.finish(handleRunning, ErrJobPanicked)
+			return
 		}
+		w.pool.metrics.complete(duration)
+		h.finish(handleRunning, ctx.Err())
 	}()
+
+	if hooks.BeforeExecute != nil {
+		if c := hooks.BeforeExecute(ctx, t.job); c != nil {
+			ctx = c
+		}
+	}
+	t.job.execute(ctx, w.state)
 }
@@ -0,0 +1,118 @@
+package pool
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// CAS连续失败的次数上限, 超过之后让出处理器, 避免在竞争激烈时空转
+const maxStackSpins = 4
+
+// idleNode 空闲栈的节点. 每次入栈分配新的节点, 节点出栈之后不会被再次使用, 由GC保证不会出现ABA问题.
+// 节点被detach或者清理取走时设置taken, 只有设置成功的一方得到该Worker
+type idleNode struct {
+	worker *Worker
+	next   atomic.Pointer[idleNode]
+	taken  int32
+}
+
+func (n *idleNode) claim() bool {
+	return atomic.CompareAndSwapInt32(&n.taken, 0, 1)
+}
+
+// workerStack 空闲Worker的无锁栈(Treiber stack, LIFO). 最近放回的Worker最先被取出, CPU缓存更热.
+// 所有方法都可以并发调用
+type workerStack struct {
+	top  atomic.Pointer[idleNode]
+	size int32 // 栈当中的Worker数量, 与top分开更新, 并发时是近似值
+}
+
+func newWorkerStack() *workerStack {
+	return &workerStack{}
+}
+
+func (ws *workerStack) len() int {
+	if n := atomic.LoadInt32(&ws.size); n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
+// 入栈
+func (ws *workerStack) insert(w *Worker) {
+	n := &idleNode{worker: w}
+	for spins := 0; ; spins++ {
+		top := ws.top.Load()
+		n.next.Store(top)
+		if ws.top.CompareAndSwap(top, n) {
+			break
+		}
+		backoff(spins)
+	}
+	atomic.AddInt32(&ws.size, 1)
+}
+
+// 出栈, 栈为空返回nil. 跳过已经被清理取走的节点
+func (ws *workerStack) detach() *Worker {
+	for spins := 0; ; spins++ {
+		n := ws.top.Load()
+		if n == nil {
+			return nil
+		}
+		if !ws.top.CompareAndSwap(n, n.next.Load()) {
+			backoff(spins)
+			continue
+		}
+		if n.claim() {
+			atomic.AddInt32(&ws.size, -1)
+			return n.worker
+		}
+	}
+}
+
+// 取出所有空闲时间超过duration的Worker. 栈顶的Worker最近放回, 从栈顶向下找到第一个过期的节点,
+// 在这里截断, 之下的节点都已经过期.
+// 并发的detach可能在截断之前读到旧的next, 把截断的节点重新放回栈顶; 这些节点已经被取走, 出栈时跳过
+func (ws *workerStack) retrieveExpiry(now time.Time, duration time.Duration) []*Worker {
+	for {
+		var prev *idleNode
+		n := ws.top.Load()
+		for n != nil && now.Sub(n.worker.recycleTime) <= duration {
+			prev, n = n, n.next.Load()
+		}
+		if n == nil {
+			return nil
+		}
+		if prev != nil {
+			prev.next.Store(nil)
+		} else if !ws.top.CompareAndSwap(n, nil) {
+			continue // 栈顶已经改变, 重新查找
+		}
+		return ws.claimAll(n)
+	}
+}
+
+// 取出全部的Worker, 从栈顶到栈底排列
+func (ws *workerStack) reset() []*Worker {
+	return ws.claimAll(ws.top.Swap(nil))
+}
+
+// 取走从n开始的链表上还没有被取走的Worker
+func (ws *workerStack) claimAll(n *idleNode) []*Worker {
+	var items []*Worker
+	for ; n != nil; n = n.next.Load() {
+		if n.claim() {
+			items = append(items, n.worker)
+		}
+	}
+	atomic.AddInt32(&ws.size, -int32(len(items)))
+	return items
+}
+
+// CAS失败之后的退避
+func backoff(spins int) {
+	if spins >= maxStackSpins {
+		runtime.Gosched()
+	}
+}